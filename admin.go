@@ -0,0 +1,595 @@
+package gojango
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gojango/database"
+	"gojango/models"
+)
+
+// adminPageSize is the number of rows on each admin list page
+const adminPageSize = 25
+
+// adminCSRFCookie holds the browser's CSRF token; every admin form posts it
+// back in the adminCSRFField field
+const (
+	adminCSRFCookie = "gojango_admin_csrf"
+	adminCSRFField  = "csrf_token"
+)
+
+// adminModel is a model registered with the admin
+type adminModel struct {
+	Name string // title shown in the admin, derived from the table name
+	Slug string // URL segment: the table name
+	URL  string // list page URL
+	// Editable is false for models without a single-column primary key (or,
+	// failing any, an id column), which are list-only
+	Editable bool
+
+	model interface{}
+	typ   reflect.Type
+
+	// columns are the columns shown on list and form pages
+	columns []database.Column
+	// idColumn is the column FindByID, Update and Delete look records up by
+	idColumn *database.Column
+}
+
+// adminField is a column on an admin form
+type adminField struct {
+	Name    string
+	Label   string
+	Input   string // text, textarea, number, checkbox, select, or "" when read-only
+	Step    string
+	Value   string
+	Checked bool
+	Choices []string
+}
+
+// adminRow is a record on an admin list page
+type adminRow struct {
+	URL   string
+	Cells []string
+}
+
+// adminPage is the data passed to the admin templates
+type adminPage struct {
+	Title  string
+	Base   string
+	Models []*adminModel
+	Model  *adminModel
+	// CSRFToken is posted back by every form
+	CSRFToken string
+
+	// List pages
+	Columns []string
+	Rows    []adminRow
+	Total   int
+	Page    int
+	Pages   int
+	PrevURL string
+	NextURL string
+
+	// Form pages
+	Action    string
+	DeleteURL string
+	Fields    []adminField
+	Errors    []string
+}
+
+// RegisterAdmin serves HTML pages under basePath to list, view, create, edit
+// and delete records of the given models, like Django's admin. Columns come
+// from the models' db tags and each model is listed under its table name:
+//
+//	GET  /admin                  index of registered models
+//	GET  /admin/users?page=2     paginated list (QuerySet, 25 per page)
+//	GET  /admin/users/new        create form
+//	GET  /admin/users/5          edit form
+//	POST /admin/users/5/delete   delete
+//
+// Forms go through models.Validate and the same Create and Update calls as
+// RegisterCRUD. Primary keys and other types a form can't edit (times,
+// JSON) are shown read-only; models without a single-column primary key are
+// list-only. Columns tagged hashed or json:"-" are left out of both pages.
+//
+// auth runs before every admin page and must reject requests that aren't
+// allowed in; RegisterAdmin returns an error without it:
+//
+//	admin, err := app.RegisterAdmin("/admin", func(c *gojango.Context) error {
+//		return middleware.BasicAuth("admin", os.Getenv("ADMIN_PASSWORD"))(c)
+//	}, &User{}, &Post{})
+//
+// Forms carry a CSRF token tied to a cookie, and POSTs without a matching
+// token are refused with 403, so other sites can't submit them with the
+// browser's credentials.
+func (app *App) RegisterAdmin(basePath string, auth Middleware, registered ...interface{}) (*RouteGroup, error) {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if auth == nil {
+		return nil, fmt.Errorf("admin at %q needs an auth middleware", basePath+"/")
+	}
+
+	group := app.Group(basePath)
+	group.Use(auth)
+
+	var adminModels []*adminModel
+	for _, model := range registered {
+		modelType := app.registerModel(model)
+
+		slug := app.db.GetTableName(model)
+		am := &adminModel{
+			Name:  adminTitle(slug),
+			Slug:  slug,
+			URL:   basePath + "/" + slug,
+			model: model,
+			typ:   modelType,
+		}
+
+		// Records are addressed like FindByID does: by the primary key, or
+		// the id column of a model that declares none
+		columns := database.Columns(model)
+		var keys, ids []database.Column
+		for _, column := range columns {
+			if column.PrimaryKey {
+				keys = append(keys, column)
+			}
+			if column.Name == "id" {
+				ids = append(ids, column)
+			}
+			if !adminHidden(column) {
+				am.columns = append(am.columns, column)
+			}
+		}
+		if len(keys) == 0 {
+			keys = ids
+		}
+		if len(keys) == 1 {
+			am.idColumn = &keys[0]
+			am.Editable = true
+		}
+		adminModels = append(adminModels, am)
+	}
+
+	page := func(title string) adminPage {
+		return adminPage{Title: title, Base: basePath, Models: adminModels}
+	}
+
+	fail := func(c *Context, err error) error {
+		code := 500
+		if errors.Is(err, database.ErrNotFound) {
+			code = 404
+		} else if errors.Is(err, database.ErrRestricted) {
+			code = 409
+		}
+		data := page(fmt.Sprintf("%d %s", code, http.StatusText(code)))
+		data.Errors = []string{err.Error()}
+		return renderAdmin(c, code, "error", data)
+	}
+
+	group.Use(func(c *Context) error {
+		if c.Request.Method != http.MethodPost || adminCSRFValid(c) {
+			return nil
+		}
+		c.Abort()
+		data := page("403 Forbidden")
+		data.Errors = []string{"CSRF token missing or incorrect; reload the form and try again"}
+		return renderAdmin(c, 403, "error", data)
+	})
+
+	group.GET("", func(c *Context) error {
+		return renderAdmin(c, 200, "index", page("Administration"))
+	})
+
+	for _, am := range adminModels {
+		am := am
+
+		// List
+		group.GET("/"+am.Slug, func(c *Context) error {
+			data := page(am.Name)
+			data.Model = am
+			if err := app.adminList(c, am, &data); err != nil {
+				return fail(c, err)
+			}
+			return renderAdmin(c, 200, "list", data)
+		})
+
+		if !am.Editable {
+			continue
+		}
+
+		// Create; registered before /:id so "new" isn't taken for an id
+		group.GET("/"+am.Slug+"/new", func(c *Context) error {
+			record := newModel(am.typ)
+			return renderAdmin(c, 200, "form", am.formPage(page("Add "+am.Name), record, "", nil))
+		})
+
+		group.POST("/"+am.Slug+"/new", func(c *Context) error {
+			record := newModel(am.typ)
+			if errs := am.bindForm(c, record); len(errs) > 0 {
+				return renderAdmin(c, 400, "form", am.formPage(page("Add "+am.Name), record, "", errs))
+			}
+
+			if err := app.db.CreateContext(c.Request.Context(), record); err != nil {
+				return renderAdmin(c, 500, "form", am.formPage(page("Add "+am.Name), record, "", []string{err.Error()}))
+			}
+			return c.Redirect(303, am.URL)
+		})
+
+		// Edit
+		group.GET("/"+am.Slug+"/:id", func(c *Context) error {
+			id := c.Param("id")
+			record := newModel(am.typ)
+			if err := app.db.FindByIDContext(c.Request.Context(), record, id); err != nil {
+				return fail(c, err)
+			}
+			return renderAdmin(c, 200, "form", am.formPage(page("Change "+am.Name), record, id, nil))
+		})
+
+		group.POST("/"+am.Slug+"/:id", func(c *Context) error {
+			id := c.Param("id")
+			record := newModel(am.typ)
+
+			// Start from the stored record so columns missing from the form keep their values
+			if err := app.db.FindByIDContext(c.Request.Context(), record, id); err != nil {
+				return fail(c, err)
+			}
+
+			if errs := am.bindForm(c, record); len(errs) > 0 {
+				return renderAdmin(c, 400, "form", am.formPage(page("Change "+am.Name), record, id, errs))
+			}
+
+			if err := app.db.UpdateContext(c.Request.Context(), record, id); err != nil {
+				return renderAdmin(c, 500, "form", am.formPage(page("Change "+am.Name), record, id, []string{err.Error()}))
+			}
+			return c.Redirect(303, am.URL)
+		})
+
+		// Delete
+		group.POST("/"+am.Slug+"/:id/delete", func(c *Context) error {
+			record := newModel(am.typ)
+			if err := app.db.DeleteContext(c.Request.Context(), record, c.Param("id")); err != nil {
+				return fail(c, err)
+			}
+			return c.Redirect(303, am.URL)
+		})
+	}
+
+	return group, nil
+}
+
+// adminCSRFToken returns the browser's CSRF token, setting a new one in the
+// cookie when it has none
+func adminCSRFToken(c *Context, basePath string) string {
+	if cookie, err := c.Request.Cookie(adminCSRFCookie); err == nil && len(cookie.Value) == 43 {
+		return cookie.Value
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		// Without a token every POST is refused, which is the safe failure
+		return ""
+	}
+	value := base64.RawURLEncoding.EncodeToString(token)
+
+	path := basePath
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(c.Response, &http.Cookie{
+		Name:     adminCSRFCookie,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return value
+}
+
+// adminCSRFValid reports whether a POST carries the token of its cookie
+func adminCSRFValid(c *Context) bool {
+	cookie, err := c.Request.Cookie(adminCSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		return false
+	}
+	submitted := c.Request.PostForm.Get(adminCSRFField)
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(cookie.Value)) == 1
+}
+
+// adminList fills data with one page of am's records
+func (app *App) adminList(c *Context, am *adminModel, data *adminPage) error {
+	qs := NewQuerySet(app.db, am.model).WithContext(c.Request.Context())
+	if am.idColumn != nil {
+		qs = qs.OrderBy(am.idColumn.Name)
+	}
+
+	page, err := qs.Paginate(c.QueryIntDefault("page", 1), adminPageSize)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range am.columns {
+		data.Columns = append(data.Columns, column.Name)
+	}
+
+	records := reflect.ValueOf(page.Results)
+	for i := 0; i < records.Len(); i++ {
+		record := reflect.Indirect(records.Index(i))
+
+		var row adminRow
+		for _, column := range am.columns {
+			row.Cells = append(row.Cells, adminValue(record.FieldByIndex(column.Index)))
+		}
+		if am.idColumn != nil {
+			id := adminValue(record.FieldByIndex(am.idColumn.Index))
+			row.URL = am.URL + "/" + url.PathEscape(id)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+
+	data.Total = page.Count
+	data.Page = page.Page
+	data.Pages = page.NumPages
+	if page.Page > 1 {
+		data.PrevURL = am.URL + "?page=" + strconv.Itoa(page.Page-1)
+	}
+	if page.Page < page.NumPages {
+		data.NextURL = am.URL + "?page=" + strconv.Itoa(page.Page+1)
+	}
+	return nil
+}
+
+// formPage builds the create (id "") or edit page for record
+func (am *adminModel) formPage(data adminPage, record interface{}, id string, errs []string) adminPage {
+	data.Model = am
+	data.Errors = errs
+	data.Action = am.URL + "/new"
+	if id != "" {
+		data.Action = am.URL + "/" + url.PathEscape(id)
+		data.DeleteURL = data.Action + "/delete"
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(record))
+	for _, column := range am.columns {
+		fieldValue := value.FieldByIndex(column.Index)
+		field := adminField{
+			Name:  column.Name,
+			Label: column.Field.Name,
+			Input: adminInput(column),
+			Value: adminValue(fieldValue),
+		}
+
+		switch field.Input {
+		case "checkbox":
+			field.Checked = fieldValue.Bool()
+		case "number":
+			field.Step = "1"
+			if k := column.Field.Type.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+				field.Step = "any"
+			}
+		case "select":
+			field.Choices = strings.Split(column.Field.Tag.Get("choices"), "|")
+		}
+
+		data.Fields = append(data.Fields, field)
+	}
+	return data
+}
+
+// bindForm copies the submitted editable columns into record and validates
+// it, returning the messages to show on the form
+func (am *adminModel) bindForm(c *Context, record interface{}) []string {
+	if err := c.Request.ParseForm(); err != nil {
+		return []string{err.Error()}
+	}
+
+	values := make(url.Values)
+	for _, column := range am.columns {
+		switch adminInput(column) {
+		case "":
+			continue
+		case "checkbox":
+			// Unchecked boxes aren't submitted at all
+			values.Set(column.Name, strconv.FormatBool(c.Request.PostForm.Get(column.Name) != ""))
+		default:
+			if submitted, ok := c.Request.PostForm[column.Name]; ok {
+				values[column.Name] = submitted
+			}
+		}
+	}
+
+	if err := bindValues(values, record, "db", "field"); err != nil {
+		return []string{err.Error()}
+	}
+
+	var messages []string
+	for _, err := range models.Validate(record) {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+// adminHidden reports whether a column is kept off admin pages: password
+// hashes and fields the model hides from JSON
+func adminHidden(column database.Column) bool {
+	for _, option := range column.Options {
+		if option == models.TagHashed {
+			return true
+		}
+	}
+	return strings.Split(column.Field.Tag.Get(models.TagJSON), ",")[0] == "-"
+}
+
+// adminInput returns the form input used for a column, or "" if the admin
+// can't edit it
+func adminInput(column database.Column) string {
+	if column.PrimaryKey || column.AutoIncrement {
+		return ""
+	}
+
+	t := column.Field.Type
+	if t.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem()) ||
+		reflect.PtrTo(t).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()) {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "checkbox"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		if column.Field.Tag.Get("choices") != "" {
+			return "select"
+		}
+		for _, option := range column.Options {
+			if strings.EqualFold(option, "type:TEXT") {
+				return "textarea"
+			}
+		}
+		return "text"
+	}
+	return ""
+}
+
+// adminValue formats a field for display
+func adminValue(value reflect.Value) string {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	switch v := value.Interface().(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("2006-01-02 15:04:05")
+	case []byte:
+		return fmt.Sprintf("%d bytes", len(v))
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Struct:
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// adminTitle turns a table name like "blog_posts" into "Blog posts"
+func adminTitle(table string) string {
+	title := strings.ReplaceAll(table, "_", " ")
+	if title == "" {
+		return title
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+// renderAdmin renders one of the built-in admin templates
+func renderAdmin(c *Context, code int, name string, data adminPage) error {
+	data.CSRFToken = adminCSRFToken(c, data.Base)
+
+	var buf bytes.Buffer
+	if err := adminTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return c.ErrorJSON(500, "Template error", err)
+	}
+	return c.Blob(code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// adminTemplates are the built-in admin pages
+var adminTemplates = template.Must(template.New("admin").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} | Admin</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #222; }
+header { background: #417690; color: #fff; padding: 12px 24px; }
+header a { color: #fff; text-decoration: none; font-weight: bold; }
+main { padding: 16px 24px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 6px 10px; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+label { display: block; font-weight: bold; margin-top: 12px; }
+input[type=text], input[type=number], select, textarea { width: 100%; max-width: 480px; padding: 4px; }
+.errors { color: #ba2121; }
+.button { display: inline-block; margin-top: 16px; padding: 6px 14px; background: #417690; color: #fff; border: 0; text-decoration: none; cursor: pointer; }
+.delete { background: #ba2121; }
+</style>
+</head>
+<body>
+<header><a href="{{.Base}}">Administration</a></header>
+<main>
+<h1>{{.Title}}</h1>
+{{with .Errors}}<ul class="errors">{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{end}}
+
+{{define "footer"}}</main>
+</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" .}}
+<table>
+<tr><th>Model</th><th></th></tr>
+{{range .Models}}<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td>{{if .Editable}}<a href="{{.URL}}/new">Add</a>{{end}}</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "list"}}{{template "header" .}}
+{{if .Model.Editable}}<p><a class="button" href="{{.Model.URL}}/new">Add {{.Model.Name}}</a></p>{{end}}
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{$url := .URL}}{{range $i, $cell := .Cells}}<td>{{if and (eq $i 0) $url}}<a href="{{$url}}">{{$cell}}</a>{{else}}{{$cell}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+<p>{{.Total}} total. Page {{.Page}} of {{.Pages}}.
+{{with .PrevURL}}<a href="{{.}}">Previous</a>{{end}}
+{{with .NextURL}}<a href="{{.}}">Next</a>{{end}}</p>
+{{template "footer" .}}{{end}}
+
+{{define "form"}}{{template "header" .}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+{{range .Fields}}<label for="{{.Name}}">{{.Label}}</label>
+{{if eq .Input "text"}}<input type="text" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}">
+{{else if eq .Input "number"}}<input type="number" step="{{.Step}}" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}">
+{{else if eq .Input "textarea"}}<textarea id="{{.Name}}" name="{{.Name}}" rows="6">{{.Value}}</textarea>
+{{else if eq .Input "checkbox"}}<input type="checkbox" id="{{.Name}}" name="{{.Name}}" value="true"{{if .Checked}} checked{{end}}>
+{{else if eq .Input "select"}}<select id="{{.Name}}" name="{{.Name}}">{{$value := .Value}}{{range .Choices}}<option{{if eq . $value}} selected{{end}}>{{.}}</option>{{end}}</select>
+{{else}}<div>{{.Value}}</div>
+{{end}}{{end}}
+<button class="button" type="submit">Save</button>
+</form>
+{{with .DeleteURL}}<form method="post" action="{{.}}"><input type="hidden" name="csrf_token" value="{{$.CSRFToken}}"><button class="button delete" type="submit">Delete</button></form>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "error"}}{{template "header" .}}{{template "footer" .}}{{end}}
+`))
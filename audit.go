@@ -0,0 +1,169 @@
+package gojango
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"gojango/database"
+	"gojango/middleware"
+	"gojango/signals"
+)
+
+// AuditEntry is a row of the audit_log table written by EnableAuditLog
+type AuditEntry struct {
+	ID uint `json:"id" db:"id,primary_key,auto_increment"`
+	// Model is the table of the changed record
+	Model    string `json:"model" db:"model,not_null,index:audit_object"`
+	ObjectID string `json:"object_id" db:"object_id,index:audit_object"`
+	// Action is "create", "update" or "delete"
+	Action string `json:"action" db:"action,not_null"`
+	// Before and After are JSON snapshots of the record; Before is empty for
+	// creates and After for deletes
+	Before string `json:"before,omitempty" db:"before_data"`
+	After  string `json:"after,omitempty" db:"after_data"`
+	// UserID is the primary key of the authenticated user, if any
+	UserID    string    `json:"user_id,omitempty" db:"user_id,index"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName stores audit entries in audit_log
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// requestContextKey stores the *Context in its request's context, so
+// signal receivers can reach the request's values
+type requestContextKey struct{}
+
+// auditLog records the changes made while serving requests
+type auditLog struct {
+	app *App
+	// before holds the snapshot taken by a pre_ signal until its post_ signal
+	before sync.Map
+}
+
+// EnableAuditLog records every Create, Update and Delete made with a
+// request's context (as the CRUD endpoints do) in the audit_log table: the
+// model, the action, JSON snapshots of the record before and after, and the
+// primary key of the authenticated user stored under middleware.UserKey.
+// The table is migrated here. Read the entries with AuditLog. Changes made
+// outside a request, or with another context, aren't recorded; neither are
+// bulk QuerySet updates and deletes, which send no signals.
+func (app *App) EnableAuditLog() error {
+	if app.audit != nil {
+		return nil
+	}
+	if err := app.AutoMigrate(&AuditEntry{}); err != nil {
+		return err
+	}
+
+	audit := &auditLog{app: app}
+	app.audit = audit
+	signals.Connect(signals.PreSave, audit.snapshot)
+	signals.Connect(signals.PreDelete, audit.snapshot)
+	signals.Connect(signals.PostSave, audit.record)
+	signals.Connect(signals.PostDelete, audit.record)
+	return nil
+}
+
+// AuditLog returns a QuerySet over the audit entries, newest first:
+//
+//	var entries []gojango.AuditEntry
+//	err := app.AuditLog().Filter("model", "users").Filter("object_id", "7").Scan(&entries)
+func (app *App) AuditLog() *QuerySet {
+	return app.NewQuerySet(&AuditEntry{}).OrderBy("-id")
+}
+
+// request returns the Context of the app's request e was sent for, if any
+func (a *auditLog) request(e signals.Event) (*Context, bool) {
+	if _, isEntry := e.Model.(*AuditEntry); isEntry {
+		return nil, false
+	}
+	c, ok := e.Context.Value(requestContextKey{}).(*Context)
+	if !ok || c.app != a.app || a.app.db == nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// snapshot loads the record an update or delete is about to change
+func (a *auditLog) snapshot(e signals.Event) {
+	if e.Created || e.ID == nil {
+		return
+	}
+	if _, ok := a.request(e); !ok {
+		return
+	}
+
+	current := newModel(structType(e.Model))
+	if err := a.app.db.FindByIDContext(database.UsePrimary(e.Context), current, e.ID); err != nil {
+		return
+	}
+	if data, err := json.Marshal(current); err == nil {
+		a.before.Store(e.Model, string(data))
+	}
+}
+
+// record writes the audit entry for a completed change
+func (a *auditLog) record(e signals.Event) {
+	c, ok := a.request(e)
+	if !ok {
+		return
+	}
+
+	entry := &AuditEntry{
+		Model:     a.app.db.GetTableName(e.Model),
+		CreatedAt: time.Now(),
+		UserID:    auditUserID(c),
+	}
+	if before, ok := a.before.LoadAndDelete(e.Model); ok {
+		entry.Before = before.(string)
+	}
+
+	switch {
+	case e.Signal == signals.PostDelete:
+		entry.Action = AuditDelete
+		entry.ObjectID = fmt.Sprint(e.ID)
+	case e.Created:
+		entry.Action = AuditCreate
+		entry.ObjectID, _ = primaryKey(e.Model)
+	default:
+		entry.Action = AuditUpdate
+		entry.ObjectID = fmt.Sprint(e.ID)
+	}
+	if entry.Action != AuditDelete {
+		if data, err := json.Marshal(e.Model); err == nil {
+			entry.After = string(data)
+		}
+	}
+
+	if err := a.app.db.CreateContext(context.WithoutCancel(e.Context), entry); err != nil {
+		log.Printf("Audit log write failed: %v", err)
+	}
+}
+
+// auditUserID returns the primary key of the request's authenticated user,
+// or the value itself when it is a plain ID
+func auditUserID(c *Context) string {
+	user, ok := c.Get(middleware.UserKey)
+	if !ok || user == nil {
+		return ""
+	}
+	if value := reflect.ValueOf(user); value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct {
+		id, _ := primaryKey(user)
+		return id
+	}
+	return fmt.Sprint(user)
+}
@@ -0,0 +1,116 @@
+package gojango
+
+import (
+	"context"
+	"fmt"
+
+	"gojango/database"
+)
+
+// Batch collects the writes of a request made with Context.Batch. Each call
+// runs at once inside the batch's transaction, so a created parent's ID can
+// be used by the children that follow. After the first failure the remaining
+// calls are skipped.
+type Batch struct {
+	ctx context.Context
+	tx  *database.DB
+	err error
+}
+
+// Batch runs fn's writes in one transaction bound to the request's context,
+// so they are rolled back if any of them fails, fn panics, or the client goes
+// away before the commit:
+//
+//	err := c.Batch(func(b *gojango.Batch) {
+//		b.Create(&post)
+//		comment.PostID = post.ID
+//		b.Create(&comment)
+//	})
+//	if err != nil {
+//		return err // answered with a 500
+//	}
+//
+// It returns the first error. After a commit the rest of the request reads
+// from the primary database, so the handler can read back what it wrote. The
+// mock database runs the writes without a transaction and can't roll back.
+func (c *Context) Batch(fn func(b *Batch)) error {
+	if c.app == nil || c.app.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	ctx := c.Request.Context()
+	err := c.app.db.TransactionContext(ctx, func(tx *database.DB) error {
+		b := &Batch{ctx: ctx, tx: tx}
+		fn(b)
+		return b.err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.UsePrimary()
+	return nil
+}
+
+// Create inserts model
+func (b *Batch) Create(model interface{}) {
+	b.run("create", model, func() error {
+		return b.tx.CreateContext(b.ctx, model)
+	})
+}
+
+// Update saves every column of model to the row with the given id
+func (b *Batch) Update(model interface{}, id interface{}) {
+	b.run("update", model, func() error {
+		return b.tx.UpdateContext(b.ctx, model, id)
+	})
+}
+
+// UpdateFields saves only the named columns of model
+func (b *Batch) UpdateFields(model interface{}, id interface{}, columns ...string) {
+	b.run("update", model, func() error {
+		return b.tx.UpdateFieldsContext(b.ctx, model, id, columns...)
+	})
+}
+
+// Delete removes the row of model's table with the given id
+func (b *Batch) Delete(model interface{}, id interface{}) {
+	b.run("delete", model, func() error {
+		return b.tx.DeleteContext(b.ctx, model, id)
+	})
+}
+
+// Fail aborts the batch with err, e.g. when a check between writes fails.
+// Like a failed write, it skips the remaining calls and rolls back.
+func (b *Batch) Fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Err returns the error that aborted the batch, if any
+func (b *Batch) Err() error {
+	return b.err
+}
+
+// Tx returns the batch's transaction, for queries the Batch methods don't
+// cover, e.g. gojango.NewQuerySet(b.Tx(), &Post{}). Pass b.Context() to its
+// *Context methods so they honor the request's deadline.
+func (b *Batch) Tx() *database.DB {
+	return b.tx
+}
+
+// Context returns the request context the batch runs with
+func (b *Batch) Context() context.Context {
+	return b.ctx
+}
+
+// run performs one write unless the batch has already failed
+func (b *Batch) run(action string, model interface{}, write func() error) {
+	if b.err != nil {
+		return
+	}
+	if err := write(); err != nil {
+		b.err = fmt.Errorf("batch %s %s: %w", action, b.tx.GetTableName(model), err)
+	}
+}
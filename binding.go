@@ -0,0 +1,211 @@
+package gojango
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gojango/models"
+)
+
+// BindQuery populates a struct from the URL query string. Fields are matched
+// by their `query` tag, falling back to the json tag and then the field name.
+// Repeated parameters fill slice fields.
+//
+//	type Filter struct {
+//		Name   string   `query:"name"`
+//		Page   int      `query:"page"`
+//		Active bool     `query:"active"`
+//		Tags   []string `query:"tag"`
+//	}
+func (c *Context) BindQuery(v interface{}) error {
+	return bindValues(c.Request.URL.Query(), v, "query", "query parameter")
+}
+
+// BindForm populates a struct from a submitted HTML form, either
+// application/x-www-form-urlencoded or multipart/form-data. Fields are
+// matched by their `form` tag, falling back to the json tag and then the
+// field name, and converted to the field's type; repeated fields (such as a
+// multiple select) fill slices:
+//
+//	type Signup struct {
+//		Email string   `form:"email"`
+//		Age   int      `form:"age"`
+//		Terms bool     `form:"terms"`
+//		Tags  []string `form:"tag"`
+//	}
+//
+// Only the request body is read, not the URL query. A value that doesn't
+// convert is reported in a *BindError listing every such field.
+func (c *Context) BindForm(v interface{}) error {
+	if strings.HasPrefix(c.GetHeader("Content-Type"), "multipart/form-data") {
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+			return fmt.Errorf("failed to parse form: %v", err)
+		}
+	} else if err := c.Request.ParseForm(); err != nil {
+		return fmt.Errorf("failed to parse form: %v", err)
+	}
+	return bindValues(c.Request.PostForm, v, "form", "form field")
+}
+
+// BindError lists the values BindQuery or BindForm couldn't convert to their
+// fields' types, so a form can be shown again with a message for each
+type BindError struct {
+	// Source names where the values came from, e.g. "form field"
+	Source string
+	// Fields holds each failed parameter's name and the reason, in field order
+	Fields models.ValidationErrors
+}
+
+func (e *BindError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = e.Source + " " + field.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// bindValues copies url.Values into the struct pointed to by v, using tag to
+// find each field's key. kind names the source in error messages.
+func bindValues(values url.Values, v interface{}, tag, kind string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind target must be a pointer to a struct, got %T", v)
+	}
+
+	bindErr := &BindError{Source: kind}
+	bindStruct(values, rv.Elem(), tag, bindErr)
+	if len(bindErr.Fields) > 0 {
+		return bindErr
+	}
+	return nil
+}
+
+// bindStruct binds each exported field of a struct value, adding the values
+// that don't convert to bindErr
+func bindStruct(values url.Values, rv reflect.Value, tag string, bindErr *BindError) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := rv.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get(tag) == "" {
+			bindStruct(values, fieldValue, tag, bindErr)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := bindingName(field, tag)
+		if name == "-" {
+			continue
+		}
+
+		raw, exists := values[name]
+		if !exists || len(raw) == 0 {
+			continue
+		}
+
+		if err := setFieldFromStrings(fieldValue, raw); err != nil {
+			bindErr.Fields = append(bindErr.Fields, models.ValidationError{Field: name, Message: err.Error()})
+		}
+	}
+}
+
+// bindingName returns the key a field binds from
+func bindingName(field reflect.StructField, tag string) string {
+	if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" {
+		return name
+	}
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// setFieldFromStrings converts raw values to the field's type. Slices take
+// every value; other types take the first.
+func setFieldFromStrings(field reflect.Value, raw []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(field.Type(), len(raw), len(raw))
+		for i, s := range raw {
+			if err := setFieldFromString(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setFieldFromString(field, raw[0])
+}
+
+// formTimeLayouts are the formats time fields are bound from
+var formTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// setFieldFromString converts a single value to the field's type
+func setFieldFromString(field reflect.Value, s string) error {
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		if err := setFieldFromString(elem.Elem(), s); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+
+	// Dates and times as HTML date, datetime-local and RFC 3339 values
+	if field.Type() == timeType {
+		for _, layout := range formTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				field.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot convert %q to a date or time", s)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot convert %q to %s", s, field.Type())
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot convert %q to %s", s, field.Type())
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot convert %q to %s", s, field.Type())
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		// Checked HTML checkboxes submit "on" by default
+		if strings.EqualFold(s, "on") {
+			field.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("cannot convert %q to %s", s, field.Type())
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// []byte
+		field.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
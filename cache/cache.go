@@ -0,0 +1,40 @@
+// Package cache provides a small key/value cache interface with an
+// in-memory LRU implementation and a Redis client.
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Cache stores byte values under string keys. A ttl of 0 keeps the value
+// until it is deleted or evicted. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the value for key and whether it was found
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// GetJSON decodes the value for key into v and reports whether it was found
+func GetJSON(c Cache, key string, v interface{}) (bool, error) {
+	data, ok, err := c.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode cached %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// SetJSON stores v encoded as JSON
+func SetJSON(c Cache, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s for caching: %v", key, err)
+	}
+	return c.Set(key, data, ttl)
+}
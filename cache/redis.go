@@ -0,0 +1,301 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Redis is a Cache backed by a Redis server. It speaks the RESP protocol
+// directly and keeps a small pool of connections, so it needs no client
+// library.
+type Redis struct {
+	addr    string
+	options redisOptions
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// redisOptions holds the settings applied by RedisOption values
+type redisOptions struct {
+	password string
+	db       int
+	timeout  time.Duration
+	poolSize int
+	prefix   string
+}
+
+// RedisOption configures a Redis cache
+type RedisOption func(*redisOptions)
+
+// WithPassword authenticates each connection with AUTH
+func WithPassword(password string) RedisOption {
+	return func(o *redisOptions) {
+		o.password = password
+	}
+}
+
+// WithDB selects a database number other than 0
+func WithDB(db int) RedisOption {
+	return func(o *redisOptions) {
+		o.db = db
+	}
+}
+
+// WithTimeout bounds dialing and each command (default 3s)
+func WithTimeout(timeout time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithPoolSize sets how many idle connections are kept (default 10)
+func WithPoolSize(size int) RedisOption {
+	return func(o *redisOptions) {
+		o.poolSize = size
+	}
+}
+
+// WithPrefix prepends prefix to every key, to share a server between apps
+func WithPrefix(prefix string) RedisOption {
+	return func(o *redisOptions) {
+		o.prefix = prefix
+	}
+}
+
+// RedisError is an error reply from the server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a pooled connection with its reader
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedis creates a cache for the server at addr ("host:port").
+// Connections are opened on first use.
+func NewRedis(addr string, opts ...RedisOption) *Redis {
+	options := redisOptions{timeout: 3 * time.Second, poolSize: 10}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &Redis{addr: addr, options: options}
+}
+
+// Get returns the value for key
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := r.do("GET", r.options.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+// Set stores value under key, expiring it after ttl when ttl > 0
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", r.options.prefix + key, value}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+
+	_, err := r.do(args...)
+	return err
+}
+
+// Delete removes key
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", r.options.prefix+key)
+	return err
+}
+
+// Ping checks that the server answers
+func (r *Redis) Ping() error {
+	_, err := r.do("PING")
+	return err
+}
+
+// Close closes the idle connections; the cache can't be used afterwards
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	for _, c := range r.idle {
+		c.conn.Close()
+	}
+	r.idle = nil
+	return nil
+}
+
+// do runs a command on a pooled connection. Connections that fail at the
+// network level are discarded; error replies leave them usable.
+func (r *Redis) do(args ...interface{}) (interface{}, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(r.options.timeout, args...)
+	var replyErr RedisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+
+	r.put(c)
+	return reply, err
+}
+
+// get takes an idle connection or dials a new one
+func (r *Redis) get() (*redisConn, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, errors.New("redis: cache is closed")
+	}
+	if n := len(r.idle); n > 0 {
+		c := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return c, nil
+	}
+	r.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", r.addr, r.options.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %v", r.addr, err)
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if r.options.password != "" {
+		if _, err := c.do(r.options.timeout, "AUTH", r.options.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.options.db != 0 {
+		if _, err := c.do(r.options.timeout, "SELECT", strconv.Itoa(r.options.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full
+func (r *Redis) put(c *redisConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || len(r.idle) >= r.options.poolSize {
+		c.conn.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+}
+
+// do writes a command as a RESP array of bulk strings and reads the reply
+func (c *redisConn) do(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	if timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var data []byte
+		switch v := arg.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			return nil, fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+		buf = append(buf, "$"+strconv.Itoa(len(data))+"\r\n"...)
+		buf = append(buf, data...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+// readReply reads one RESP reply: simple strings and bulk strings become
+// []byte (nil for a null bulk string), integers int64, arrays []interface{}
+// and error replies a RedisError
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(payload), nil
+	case '-':
+		return nil, RedisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				var replyErr RedisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,65 @@
+// Command gojango is the GoJango project tool, in the spirit of Django's
+// django-admin and manage.py:
+//
+//	gojango new <project>      scaffold a project
+//	gojango runserver [addr]   run the project, rebuilding it on changes
+//	gojango makemigrations     print the schema changes migrate would make
+//	gojango migrate            apply them
+//
+// Everything but new runs inside the project with "go run .", where
+// App.Manage handles the command using the project's own models.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const usage = `Usage: gojango <command> [arguments]
+
+Commands:
+  new <project>      create a project in a new directory
+  runserver [addr]   run the project, rebuilding and restarting it on changes
+  makemigrations     print the SQL migrate would run
+  migrate            create missing tables and columns
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "new":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, "Usage: gojango new <project>\n")
+			os.Exit(2)
+		}
+		err = newProject(args[0])
+	case "runserver":
+		err = runServer(args)
+	case "makemigrations", "migrate":
+		err = goRun(append([]string{command}, args...))
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "gojango: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gojango: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// goRun runs the project in the current directory with args, which its
+// main passes to App.Manage
+func goRun(args []string) error {
+	cmd := exec.Command("go", append([]string{"run", "."}, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// projectFiles are the files new writes, relative to the project directory
+var projectFiles = map[string]string{
+	"main.go": `package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/sazardev/gojango"
+
+	"{{.Module}}/models"
+)
+
+func main() {
+	app := gojango.New()
+
+	if app.GetConfig().DatabaseURL == "" {
+		app.GetConfig().DatabaseURL = "sqlite://./db.sqlite3"
+	}
+	if err := app.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	app.RegisterCRUD("/api/posts", &models.Post{})
+
+	app.GET("/", func(c *gojango.Context) error {
+		return c.JSON(map[string]string{"message": "Welcome to {{.Name}}"})
+	})
+
+	// Management commands: makemigrations, migrate, runserver
+	if ran, err := app.Manage(os.Args[1:], models.All...); ran {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := app.Run(":8000"); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+`,
+	"models/models.go": `package models
+
+import "github.com/sazardev/gojango/models"
+
+// Post is an example model
+type Post struct {
+	models.Model
+	Title string ` + "`json:\"title\" db:\"title,not_null,size:200\"`" + `
+	Body  string ` + "`json:\"body\" db:\"body\"`" + `
+}
+
+func (p *Post) TableName() string {
+	return "posts"
+}
+
+// All lists the models makemigrations and migrate manage
+var All = []interface{}{
+	&Post{},
+}
+`,
+	"templates/.gitkeep": ``,
+	".gitignore": `db.sqlite3
+`,
+}
+
+// newProject scaffolds a project in a new directory named after it
+func newProject(dir string) error {
+	name := filepath.Base(filepath.Clean(dir))
+	if name == "." || name == string(filepath.Separator) || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid project name %q", dir)
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+
+	data := struct{ Name, Module string }{Name: name, Module: name}
+
+	for path, text := range projectFiles {
+		tmpl, err := template.New(path).Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template for %s: %v", path, err)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		err = tmpl.Execute(file, data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", target, err)
+		}
+	}
+
+	cmd := exec.Command("go", "mod", "init", data.Module)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go mod init failed: %v\n%s", err, output)
+	}
+
+	fmt.Printf(`Created %s. Next:
+
+  cd %s
+  go get github.com/sazardev/gojango
+  gojango migrate
+  gojango runserver
+`, name, dir)
+	return nil
+}
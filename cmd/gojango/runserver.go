@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rebuildDelay coalesces the burst of events an editor produces when saving
+const rebuildDelay = 200 * time.Millisecond
+
+// stopTimeout is how long the server gets to shut down before it is killed
+const stopTimeout = 5 * time.Second
+
+// watchedExtensions are the files whose changes restart the server
+var watchedExtensions = map[string]bool{
+	".go": true, ".html": true, ".tmpl": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true,
+}
+
+// runServer builds the project in the current directory and runs it with
+// "runserver [addr]", rebuilding and restarting it whenever a watched file
+// changes. A failed build is reported and retried on the next change.
+func runServer(args []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, "."); err != nil {
+		return err
+	}
+
+	binary := filepath.Join(os.TempDir(), fmt.Sprintf("gojango-runserver-%d", os.Getpid()))
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	defer os.Remove(binary)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		server, exited := startServer(binary, args)
+
+		// Wait for a change (debounced), a signal or the server exiting
+		var rebuild <-chan time.Time
+	wait:
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					stopServer(server, exited)
+					return nil
+				}
+				if !relevantChange(watcher, event) {
+					continue
+				}
+				rebuild = time.After(rebuildDelay)
+			case err, ok := <-watcher.Errors:
+				if ok {
+					log.Printf("Watcher error: %v", err)
+				}
+			case <-rebuild:
+				break wait
+			case <-signals:
+				stopServer(server, exited)
+				return nil
+			case err := <-exited:
+				if err != nil {
+					log.Printf("Server exited: %v; waiting for changes", err)
+				}
+				server, exited = nil, nil
+			}
+		}
+
+		log.Printf("Changes detected, restarting...")
+		stopServer(server, exited)
+	}
+}
+
+// startServer builds the project and starts it. It returns nil channels
+// when the build fails, which the caller's select simply never receives from.
+func startServer(binary string, args []string) (*exec.Cmd, chan error) {
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		log.Printf("Build failed: %v; waiting for changes", err)
+		return nil, nil
+	}
+
+	server := exec.Command(binary, append([]string{"runserver"}, args...)...)
+	server.Stdin, server.Stdout, server.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := server.Start(); err != nil {
+		log.Printf("Failed to start server: %v; waiting for changes", err)
+		return nil, nil
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- server.Wait() }()
+	return server, exited
+}
+
+// stopServer asks the server to shut down gracefully and kills it if it
+// hasn't exited within stopTimeout
+func stopServer(server *exec.Cmd, exited chan error) {
+	if server == nil {
+		return
+	}
+
+	// Interrupt isn't deliverable on Windows; Kill is the only option there
+	if err := server.Process.Signal(os.Interrupt); err != nil {
+		server.Process.Kill()
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		server.Process.Kill()
+		<-exited
+	}
+}
+
+// relevantChange reports whether event should restart the server, adding
+// newly created directories to the watcher
+func relevantChange(watcher *fsnotify.Watcher, event fsnotify.Event) bool {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := watchTree(watcher, event.Name); err != nil {
+				log.Printf("Watcher error: %v", err)
+			}
+			return false
+		}
+	}
+
+	if !event.Has(fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename) {
+		return false
+	}
+	return watchedExtensions[filepath.Ext(event.Name)]
+}
+
+// watchTree watches root and its subdirectories, skipping hidden ones and
+// vendor and node_modules
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		if path != root && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+			return filepath.SkipDir
+		}
+
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %v", path, err)
+		}
+		return nil
+	})
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Config holds application configuration
@@ -12,7 +15,37 @@ type Config struct {
 	Debug       bool
 	Port        string
 	Host        string
-	settings    map[string]interface{}
+
+	// ReplicaURLs are read replicas of DatabaseURL; InitDB sends reads to
+	// them in turn and writes to DatabaseURL
+	ReplicaURLs []string
+
+	// Database connection pool (defaults: 25 open, 5 idle, 5m lifetime)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// BusyTimeout is how long SQLite waits on a locked database (0 disables)
+	BusyTimeout time.Duration
+	// ConnectAttempts is how many times InitDB tries to reach the database,
+	// waiting ConnectBackoff after the first failure and doubling it after
+	// each one (defaults: 3 attempts, 500ms)
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+
+	// HTTP server timeouts (defaults: 15s read, 15s write, 60s idle, 5s header)
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	// ShutdownTimeout bounds how long Run waits for in-flight requests after
+	// SIGINT or SIGTERM (default 10s, 0 waits indefinitely)
+	ShutdownTimeout time.Duration
+
+	mu       sync.RWMutex
+	settings map[string]interface{}
+	// envPrefixes are the prefixes LoadFromEnv was called with, re-applied
+	// after each LoadFile
+	envPrefixes []string
 }
 
 // New creates a new configuration with defaults
@@ -22,20 +55,90 @@ func New() *Config {
 		Debug:       getEnvBool("DEBUG", false),
 		Port:        getEnv("PORT", "8000"),
 		Host:        getEnv("HOST", "localhost"),
-		settings:    make(map[string]interface{}),
+
+		ReplicaURLs: getEnvList("DATABASE_REPLICA_URLS"),
+
+		MaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+		BusyTimeout:     getEnvDuration("DB_BUSY_TIMEOUT", 5*time.Second),
+		ConnectAttempts: getEnvInt("DB_CONNECT_ATTEMPTS", 3),
+		ConnectBackoff:  getEnvDuration("DB_CONNECT_BACKOFF", 500*time.Millisecond),
+
+		ReadTimeout:       getEnvDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      getEnvDuration("SERVER_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:       getEnvDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		ReadHeaderTimeout: getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ShutdownTimeout:   getEnvDuration("SERVER_SHUTDOWN_TIMEOUT", 10*time.Second),
+
+		settings: make(map[string]interface{}),
 	}
 }
 
-// Set sets a configuration value
+// SetTimeouts sets the HTTP server timeouts. A zero duration means no timeout.
+func (c *Config) SetTimeouts(read, write, idle, readHeader time.Duration) {
+	c.ReadTimeout = read
+	c.WriteTimeout = write
+	c.IdleTimeout = idle
+	c.ReadHeaderTimeout = readHeader
+}
+
+// Set sets a configuration value. Config is safe for concurrent use, so
+// Set may be called from handlers while others read.
 func (c *Config) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.settings == nil {
 		c.settings = make(map[string]interface{})
 	}
 	c.settings[key] = value
 }
 
+// setLocked stores several values. c.mu must be held for writing, so
+// readers see either none or all of a file or environment load.
+func (c *Config) setLocked(values map[string]interface{}) {
+	if c.settings == nil {
+		c.settings = make(map[string]interface{})
+	}
+	for key, value := range values {
+		c.settings[key] = value
+	}
+}
+
+// GetDebug returns Debug. Use it rather than the field while a Watch may
+// reload the config; GetDatabaseURL, GetPort and GetHost do the same for
+// the other fields a config file sets.
+func (c *Config) GetDebug() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Debug
+}
+
+// GetDatabaseURL returns DatabaseURL under the config's lock
+func (c *Config) GetDatabaseURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DatabaseURL
+}
+
+// GetPort returns Port under the config's lock
+func (c *Config) GetPort() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Port
+}
+
+// GetHost returns Host under the config's lock
+func (c *Config) GetHost() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Host
+}
+
 // Get gets a configuration value with default
 func (c *Config) Get(key string, defaultValue interface{}) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	if val, exists := c.settings[key]; exists {
 		return val
 	}
@@ -58,6 +161,11 @@ func (c *Config) GetInt(key string, defaultValue int) int {
 		switch v := val.(type) {
 		case int:
 			return v
+		case int64:
+			return int(v)
+		case float64:
+			// JSON numbers decode as float64
+			return int(v)
 		case string:
 			if i, err := strconv.Atoi(v); err == nil {
 				return i
@@ -67,12 +175,83 @@ func (c *Config) GetInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// GetFloat gets a float configuration value
+func (c *Config) GetFloat(key string, defaultValue float64) float64 {
+	if val := c.Get(key, defaultValue); val != nil {
+		switch v := val.(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		case int64:
+			return float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	}
+	return defaultValue
+}
+
+// GetDuration gets a duration configuration value. Strings are parsed with
+// time.ParseDuration ("15s", "1m30s"); plain numbers are taken as seconds.
+func (c *Config) GetDuration(key string, defaultValue time.Duration) time.Duration {
+	if val := c.Get(key, defaultValue); val != nil {
+		switch v := val.(type) {
+		case time.Duration:
+			return v
+		case int:
+			return time.Duration(v) * time.Second
+		case int64:
+			return time.Duration(v) * time.Second
+		case float64:
+			return time.Duration(v * float64(time.Second))
+		case string:
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		}
+	}
+	return defaultValue
+}
+
+// GetStringSlice gets a list configuration value. Lists from config files
+// are returned as strings; a string value (e.g. from an environment
+// variable) is split on sep and each item trimmed. Missing keys return nil.
+func (c *Config) GetStringSlice(key, sep string) []string {
+	switch v := c.Get(key, nil).(type) {
+	case []string:
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return items
+	case string:
+		if v == "" {
+			return []string{}
+		}
+		items := strings.Split(v, sep)
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		return items
+	}
+	return nil
+}
+
 // GetBool gets a boolean configuration value
 func (c *Config) GetBool(key string, defaultValue bool) bool {
 	if val := c.Get(key, defaultValue); val != nil {
 		switch v := val.(type) {
 		case bool:
 			return v
+		case int:
+			return v != 0
+		case float64:
+			return v != 0
 		case string:
 			return strings.ToLower(v) == "true" || v == "1"
 		}
@@ -80,29 +259,49 @@ func (c *Config) GetBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
-// LoadFromEnv loads configuration from environment variables
+// LoadFromEnv loads configuration from environment variables; with prefix
+// "APP_", APP_SERVER_PORT is read as "server.port". Later LoadFile calls,
+// including config reloads, keep these values on top of the file's.
 func (c *Config) LoadFromEnv(prefix string) {
+	values := envSettings(prefix)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(values)
+	for _, seen := range c.envPrefixes {
+		if seen == prefix {
+			return
+		}
+	}
+	c.envPrefixes = append(c.envPrefixes, prefix)
+}
+
+// envSettings reads the environment variables starting with prefix as
+// settings, with the prefix removed and the rest lowercased and dotted
+func envSettings(prefix string) map[string]interface{} {
+	values := make(map[string]interface{})
 	for _, env := range os.Environ() {
 		parts := strings.SplitN(env, "=", 2)
 		if len(parts) != 2 {
 			continue
 		}
-		
+
 		key := parts[0]
 		value := parts[1]
-		
+
 		if prefix != "" && !strings.HasPrefix(key, prefix) {
 			continue
 		}
-		
+
 		// Remove prefix and convert to lowercase with dots
 		if prefix != "" {
 			key = strings.TrimPrefix(key, prefix)
 		}
 		key = strings.ToLower(strings.ReplaceAll(key, "_", "."))
-		
-		c.Set(key, value)
+
+		values[key] = value
 	}
+	return values
 }
 
 // getEnv gets environment variable with default
@@ -120,3 +319,34 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvInt gets environment variable as integer
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets environment variable as a duration like "30s"
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList gets a comma-separated environment variable as a list
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile loads settings from a YAML (.yaml, .yml), JSON (.json) or TOML
+// (.toml) file. Nested keys are flattened to dotted keys, so
+//
+//	server:
+//	  port: 8080
+//
+// is read with GetInt("server.port", 8000). The top-level keys database_url,
+// debug, port and host also set the matching Config fields, unless the
+// DATABASE_URL, DEBUG, PORT or HOST environment variable is set: the
+// environment always wins. Call LoadFromEnv after LoadFile to let prefixed
+// environment variables override file settings too.
+//
+// String values in the file, including list items, expand environment
+// variables written as ${VAR} or $VAR, which keeps secrets out of committed
+// files:
+//
+//	database_url: "postgres://app:${DB_PASSWORD}@db/app"
+//
+// Unset variables expand to an empty string; write $$ for a literal dollar
+// sign. Keys and values loaded by LoadFromEnv are not expanded.
+//
+// Once LoadFromEnv has been called, its variables are applied again after
+// the file, so reloading a file never undoes them.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	values := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config file type %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	settings := make(map[string]interface{})
+	flatten("", values, settings)
+	for key, value := range settings {
+		settings[key] = expandEnv(value)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(settings)
+	c.applyFileFields(settings)
+	for _, prefix := range c.envPrefixes {
+		c.setLocked(envSettings(prefix))
+	}
+	return nil
+}
+
+// applyFileFields sets the known Config fields from file settings, leaving
+// fields whose environment variable is set untouched. c.mu must be held.
+func (c *Config) applyFileFields(settings map[string]interface{}) {
+	if value, ok := settings["database_url"]; ok && os.Getenv("DATABASE_URL") == "" {
+		c.DatabaseURL = fmt.Sprint(value)
+	}
+	if value, ok := settings["debug"]; ok && os.Getenv("DEBUG") == "" {
+		switch v := value.(type) {
+		case bool:
+			c.Debug = v
+		default:
+			s := strings.ToLower(fmt.Sprint(v))
+			c.Debug = s == "true" || s == "1"
+		}
+	}
+	if value, ok := settings["port"]; ok && os.Getenv("PORT") == "" {
+		c.Port = fmt.Sprint(value)
+	}
+	if value, ok := settings["host"]; ok && os.Getenv("HOST") == "" {
+		c.Host = fmt.Sprint(value)
+	}
+}
+
+// flatten copies nested maps into out under dotted keys
+func flatten(prefix string, values map[string]interface{}, out map[string]interface{}) {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(key, v, out)
+		case map[interface{}]interface{}:
+			nested := make(map[string]interface{}, len(v))
+			for k, val := range v {
+				nested[fmt.Sprint(k)] = val
+			}
+			flatten(key, nested, out)
+		case int64:
+			// TOML decodes integers as int64
+			out[key] = int(v)
+		default:
+			out[key] = value
+		}
+	}
+}
+
+// expandEnv expands environment variables in string values and list items.
+// "$$" is kept as a literal "$".
+func expandEnv(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return os.Expand(v, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			return os.Getenv(name)
+		})
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = expandEnv(item)
+		}
+		return items
+	}
+	return value
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay coalesces the burst of events an editor produces when saving
+const reloadDelay = 100 * time.Millisecond
+
+// Watcher reloads a config file when it changes
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Watch reloads the file at path with LoadFile whenever it changes and then
+// calls onChange. The directory is watched rather than the file, so editors
+// that save by replacing the file are handled. Reload errors are logged and
+// the previous settings kept. Call Close on the returned Watcher to stop.
+func (c *Config) Watch(path string, onChange func(*Config)) (*Watcher, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config path: %v", err)
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config watcher: %v", err)
+	}
+
+	if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+		fsWatcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %v", path, err)
+	}
+
+	w := &Watcher{watcher: fsWatcher, done: make(chan struct{})}
+
+	reload := func() {
+		if err := c.LoadFile(path); err != nil {
+			log.Printf("Config reload failed: %v", err)
+			return
+		}
+		if onChange != nil {
+			onChange(c)
+		}
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, reload)
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher error: %v", err)
+			case <-w.done:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.watcher.Close()
+	})
+	return err
+}
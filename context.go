@@ -1,11 +1,23 @@
 package gojango
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
+
+	"gojango/cache"
+	"gojango/database"
+	"gojango/middleware"
+	"gojango/router"
+	"gojango/websocket"
 )
 
 // JSON sends a JSON response
@@ -14,11 +26,15 @@ func (c *Context) JSON(data interface{}) error {
 	return json.NewEncoder(c.Response).Encode(data)
 }
 
-// ErrorJSON sends an error JSON response
-func (c *Context) ErrorJSON(status int, message string, err error) error {
+// JSONStatus sends a JSON response with the given status code
+func (c *Context) JSONStatus(code int, data interface{}) error {
 	c.Response.Header().Set("Content-Type", "application/json")
-	c.Response.WriteHeader(status)
+	c.Response.WriteHeader(code)
+	return json.NewEncoder(c.Response).Encode(data)
+}
 
+// ErrorJSON sends an error JSON response
+func (c *Context) ErrorJSON(status int, message string, err error) error {
 	errorResponse := map[string]interface{}{
 		"error":  message,
 		"status": status,
@@ -28,11 +44,39 @@ func (c *Context) ErrorJSON(status int, message string, err error) error {
 		errorResponse["details"] = err.Error()
 	}
 
-	return json.NewEncoder(c.Response).Encode(errorResponse)
+	return c.JSONStatus(status, errorResponse)
+}
+
+// dbError sends 404 for database.ErrNotFound, 409 for database.ErrRestricted
+// and 500 for any other database error
+func (c *Context) dbError(err error) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return c.ErrorJSON(404, "Not found", err)
+	}
+	if errors.Is(err, database.ErrRestricted) {
+		return c.ErrorJSON(409, "Conflict", err)
+	}
+	return c.ErrorJSON(500, "Database error", err)
 }
 
 // BindJSON binds request body to a struct
 func (c *Context) BindJSON(v interface{}) error {
+	return c.bindJSON(v, false)
+}
+
+// BindJSONStrict binds the request body like BindJSON, but rejects fields
+// the struct doesn't have, so a client's typo isn't silently dropped:
+//
+//	if err := c.BindJSONStrict(&user); err != nil {
+//		return c.ErrorJSON(400, "Invalid JSON", err) // unknown field "emial"
+//	}
+func (c *Context) BindJSONStrict(v interface{}) error {
+	return c.bindJSON(v, true)
+}
+
+// bindJSON decodes the request body into v, rejecting unknown fields when
+// strict is set
+func (c *Context) bindJSON(v interface{}, strict bool) error {
 	if c.Request.Header.Get("Content-Type") != "application/json" {
 		return fmt.Errorf("content-type must be application/json")
 	}
@@ -40,19 +84,140 @@ func (c *Context) BindJSON(v interface{}) error {
 	decoder := json.NewDecoder(c.Request.Body)
 	defer c.Request.Body.Close()
 
+	if !strict {
+		return decoder.Decode(v)
+	}
+
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		// The decoder reports `json: unknown field "name"`
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
+// XML sends an XML response. Encoding errors are returned so the handler
+// wrapper can answer 500.
+func (c *Context) XML(data interface{}) error {
+	c.Response.Header().Set("Content-Type", "application/xml")
+	if _, err := io.WriteString(c.Response, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(c.Response).Encode(data)
+}
+
+// BindXML binds an XML request body to a struct
+func (c *Context) BindXML(v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if mediaType != "application/xml" && mediaType != "text/xml" {
+		return fmt.Errorf("content-type must be application/xml")
+	}
+
+	decoder := xml.NewDecoder(c.Request.Body)
+	defer c.Request.Body.Close()
+
 	return decoder.Decode(v)
 }
 
-// Param gets a URL parameter by name
-func (c *Context) Param(name string) string {
-	// First check if it's already parsed
-	if val, exists := c.Params[name]; exists {
-		return val
+// Negotiate sends data in the offered media type that best matches the
+// request's Accept header (honoring q values). Supported offers are
+// application/json, application/xml (or text/xml), text/html and text/plain;
+// HTML and plain text responses expect data to be a string. With no offers,
+// JSON and XML are offered; JSON is used when nothing matches.
+func (c *Context) Negotiate(data interface{}, offers ...string) error {
+	if len(offers) == 0 {
+		offers = []string{"application/json", "application/xml"}
 	}
 
-	// Extract from URL path (simple implementation)
-	// This would be set by the router when matching routes
-	return c.Request.URL.Query().Get(name)
+	switch negotiateContentType(c.GetHeader("Accept"), offers) {
+	case "application/xml", "text/xml":
+		return c.XML(data)
+	case "text/html":
+		if html, ok := data.(string); ok {
+			return c.HTML(html)
+		}
+		return fmt.Errorf("HTML responses require string data, got %T", data)
+	case "text/plain":
+		if text, ok := data.(string); ok {
+			return c.String(text)
+		}
+		return c.String(fmt.Sprintf("%v", data))
+	default:
+		return c.JSON(data)
+	}
+}
+
+// negotiateContentType picks the offer the Accept header prefers. Each offer
+// gets the q value of the most specific media range matching it; the highest
+// q wins and ties go to the earlier offer. It returns "" if nothing is acceptable.
+func negotiateContentType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		offerType, _, _ := strings.Cut(offer, "/")
+
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			rangeType, rangeSubtype, _ := strings.Cut(r.mediaType, "/")
+
+			var s int
+			switch {
+			case r.mediaType == offer:
+				s = 2
+			case rangeType == offerType && rangeSubtype == "*":
+				s = 1
+			case rangeType == "*" && rangeSubtype == "*":
+				s = 0
+			default:
+				continue
+			}
+
+			if s > specificity {
+				specificity, q = s, r.q
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// Param gets a URL path parameter by name, or "" if the route has no such
+// parameter. Use Query for query string values.
+func (c *Context) Param(name string) string {
+	return c.Params[name]
+}
+
+// RoutePattern returns the pattern of the matched route, e.g. "/users/:id"
+func (c *Context) RoutePattern() string {
+	return router.Pattern(c.Request)
 }
 
 // ParamInt gets a URL parameter as integer
@@ -65,6 +230,27 @@ func (c *Context) ParamInt(name string) (int, error) {
 	return strconv.Atoi(val)
 }
 
+// ParamInt64 gets a URL parameter as int64
+func (c *Context) ParamInt64(name string) (int64, error) {
+	val := c.Param(name)
+	if val == "" {
+		return 0, fmt.Errorf("parameter %s not found", name)
+	}
+
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// ParamUint gets a URL parameter as uint, matching the ID type of models.Model
+func (c *Context) ParamUint(name string) (uint, error) {
+	val := c.Param(name)
+	if val == "" {
+		return 0, fmt.Errorf("parameter %s not found", name)
+	}
+
+	id, err := strconv.ParseUint(val, 10, strconv.IntSize)
+	return uint(id), err
+}
+
 // Query gets a query parameter
 func (c *Context) Query(name string) string {
 	return c.Request.URL.Query().Get(name)
@@ -80,6 +266,34 @@ func (c *Context) QueryInt(name string) (int, error) {
 	return strconv.Atoi(val)
 }
 
+// QueryDefault gets a query parameter, or def if it is missing or empty
+func (c *Context) QueryDefault(name, def string) string {
+	if val := c.Query(name); val != "" {
+		return val
+	}
+	return def
+}
+
+// QueryIntDefault gets a query parameter as integer, or def if it is missing
+// or not a valid integer
+func (c *Context) QueryIntDefault(name string, def int) int {
+	val, err := c.QueryInt(name)
+	if err != nil {
+		return def
+	}
+	return val
+}
+
+// QueryBool reports whether a query parameter is set to true, 1, yes or on
+// (case-insensitive). Any other value, or a missing parameter, is false.
+func (c *Context) QueryBool(name string) bool {
+	switch strings.ToLower(c.Query(name)) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
+
 // FormValue gets a form value
 func (c *Context) FormValue(name string) string {
 	return c.Request.FormValue(name)
@@ -99,16 +313,132 @@ func (c *Context) HTML(html string) error {
 	return err
 }
 
-// Render renders a template with data
+// Render renders a template with data as a 200 text/html response
 func (c *Context) Render(templateName string, data interface{}) error {
+	return c.RenderStatus(200, templateName, data)
+}
+
+// RenderStatus renders a template with the given status code. The output is
+// buffered, so a template error produces a 500 JSON error instead of a
+// partially written page. The template's flashes function returns the
+// request's flash messages.
+func (c *Context) RenderStatus(code int, templateName string, data interface{}) error {
 	if c.app.templates == nil {
 		return fmt.Errorf("template engine not configured")
 	}
 
-	return c.app.templates.Render(c.Response, templateName, data)
+	var buf bytes.Buffer
+	if err := c.app.templates.RenderWith(&buf, templateName, data, c.templateFuncs()); err != nil {
+		return c.ErrorJSON(500, "Template error", err)
+	}
+
+	c.Response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response.WriteHeader(code)
+	_, err := buf.WriteTo(c.Response)
+	return err
+}
+
+// Redirect sends a redirect to location with the given status code, e.g.
+// 303 See Other after handling a form POST
+func (c *Context) Redirect(code int, location string) error {
+	http.Redirect(c.Response, c.Request, location, code)
+	return nil
+}
+
+// Blob sends raw bytes with the given status code and content type
+func (c *Context) Blob(status int, contentType string, data []byte) error {
+	c.Response.Header().Set("Content-Type", contentType)
+	c.Response.WriteHeader(status)
+	_, err := c.Response.Write(data)
+	return err
+}
+
+// Data streams r to the client with the given content type. The status is
+// whatever was set with Status, or 200.
+func (c *Context) Data(contentType string, r io.Reader) error {
+	c.Response.Header().Set("Content-Type", contentType)
+	_, err := io.Copy(c.Response, r)
+	return err
+}
+
+// File serves a file from disk. Range and conditional requests are handled
+// by http.ServeContent and the Content-Type is detected from the extension.
+// Paths containing ".." are rejected; a missing file gets a JSON 404.
+func (c *Context) File(path string) error {
+	return c.serveFile(path, "")
+}
+
+// Attachment serves a file like File, but asks the browser to download it
+// under the given filename
+func (c *Context) Attachment(path, filename string) error {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		return c.ErrorJSON(400, "Invalid attachment filename", nil)
+	}
+	return c.serveFile(path, disposition)
+}
+
+// serveFile opens path and streams it, setting Content-Disposition if given
+func (c *Context) serveFile(path, disposition string) error {
+	if hasDotDot(path) {
+		return c.ErrorJSON(400, "Invalid file path", nil)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.ErrorJSON(404, "File not found", nil)
+		}
+		return c.ErrorJSON(500, "Failed to open file", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return c.ErrorJSON(500, "Failed to open file", err)
+	}
+
+	if info.IsDir() {
+		return c.ErrorJSON(404, "File not found", nil)
+	}
+
+	if disposition != "" {
+		c.Response.Header().Set("Content-Disposition", disposition)
+	}
+
+	http.ServeContent(c.Response, c.Request, info.Name(), info.ModTime(), file)
+	return nil
+}
+
+// hasDotDot reports whether any element of path is ".."
+func hasDotDot(path string) bool {
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// Upgrade switches the request to the WebSocket protocol. If the handshake
+// is invalid an error status has already been sent and the error is returned.
+// Cross-origin handshakes are refused unless allowed with an option such as
+// websocket.AllowOrigins.
+//
+//	app.GET("/ws", func(c *gojango.Context) error {
+//		conn, err := c.Upgrade()
+//		if err != nil {
+//			return nil
+//		}
+//		defer conn.Close()
+//		...
+//	})
+func (c *Context) Upgrade(opts ...websocket.Option) (*websocket.Conn, error) {
+	return websocket.Upgrade(c.Response, c.Request, opts...)
 }
 
-// Status sets the HTTP status code
+// Status sets the HTTP status code. It is sent with the first body write, so
+// headers may still be set afterwards.
 func (c *Context) Status(code int) {
 	c.Response.WriteHeader(code)
 }
@@ -123,10 +453,13 @@ func (c *Context) GetHeader(key string) string {
 	return c.Request.Header.Get(key)
 }
 
-// Body gets the request body as bytes
+// Body gets the request body as bytes. The body is put back, so middleware
+// can read it and the handler still bind it.
 func (c *Context) Body() ([]byte, error) {
 	defer c.Request.Body.Close()
-	return io.ReadAll(c.Request.Body)
+	data, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	return data, err
 }
 
 // Method gets the HTTP method
@@ -139,6 +472,19 @@ func (c *Context) Path() string {
 	return c.Request.URL.Path
 }
 
+// RequestURI returns the request path with its query string
+func (c *Context) RequestURI() string {
+	return c.Request.URL.RequestURI()
+}
+
+// Cache returns the app's cache (see App.Cache)
+func (c *Context) Cache() cache.Cache {
+	if c.app == nil {
+		return nil
+	}
+	return c.app.Cache()
+}
+
 // IsAjax checks if the request is an AJAX request
 func (c *Context) IsAjax() bool {
 	return strings.ToLower(c.GetHeader("X-Requested-With")) == "xmlhttprequest"
@@ -163,17 +509,157 @@ func (c *Context) ClientIP() string {
 	return c.Request.RemoteAddr
 }
 
-// Set stores a value in the context (for middleware communication)
+// Next runs the rest of the chain (the remaining middleware and the handler)
+// and returns the first error. Middleware calls it to act after the handler,
+// e.g. to inspect or rewrite the response:
+//
+//	func Timer(c *gojango.Context) error {
+//		start := time.Now()
+//		err := c.Next()
+//		log.Printf("%s took %v", c.Path(), time.Since(start))
+//		return err
+//	}
+//
+// Middleware that returns without calling Next is followed by the rest of
+// the chain automatically, unless it calls Abort.
+func (c *Context) Next() error {
+	c.index++
+	for c.index < len(c.handlers) && !c.aborted {
+		if err := c.handlers[c.index](c); err != nil {
+			return err
+		}
+		c.index++
+	}
+	return nil
+}
+
+// Abort stops the chain after the current middleware returns: the remaining
+// middleware and the handler don't run. Middleware that rejects a request
+// writes its own response and aborts:
+//
+//	if c.GetHeader("X-Token") != token {
+//		c.Abort()
+//		return c.ErrorJSON(401, "Unauthorized", nil)
+//	}
+func (c *Context) Abort() {
+	c.aborted = true
+}
+
+// IsAborted reports whether Abort was called
+func (c *Context) IsAborted() bool {
+	return c.aborted
+}
+
+// Writer returns the response writer
+func (c *Context) Writer() http.ResponseWriter {
+	return c.Response
+}
+
+// SetWriter replaces the response writer, letting middleware wrap it (for
+// example to buffer the body) before calling Next
+func (c *Context) SetWriter(w http.ResponseWriter) {
+	c.Response = w
+}
+
+// Set stores a value in the context (for middleware communication). The
+// value is kept as-is, so Get returns it with its original type.
 func (c *Context) Set(key string, value interface{}) {
-	if c.Params == nil {
-		c.Params = make(map[string]string)
+	if c.values == nil {
+		c.values = make(map[string]interface{})
 	}
-	// Using string conversion for simplicity - in production you'd want a proper context store
-	c.Params["__context_"+key] = fmt.Sprintf("%v", value)
+	c.values[key] = value
+}
+
+// UsePrimary makes the rest of the request read from the primary database
+// even when replicas are configured, so a handler can read back what it just
+// wrote. It applies to queries made with c.Request.Context(), as the CRUD
+// endpoints and QuerySet.WithContext do.
+func (c *Context) UsePrimary() {
+	c.Request = c.Request.WithContext(database.UsePrimary(c.Request.Context()))
 }
 
-// Get retrieves a value from the context
+// RequestID returns the ID middleware.RequestID assigned to the request, or
+// "" if that middleware isn't installed
+func (c *Context) RequestID() string {
+	return c.GetString(middleware.RequestIDKey)
+}
+
+// Get retrieves a value stored with Set
 func (c *Context) Get(key string) (interface{}, bool) {
-	val, exists := c.Params["__context_"+key]
+	val, exists := c.values[key]
 	return val, exists
 }
+
+// MustGet returns the value stored under key, panicking if it is absent
+func (c *Context) MustGet(key string) interface{} {
+	if val, exists := c.Get(key); exists {
+		return val
+	}
+	panic(fmt.Sprintf("key %q does not exist in context", key))
+}
+
+// GetString returns the string stored under key, or "" if it is absent or
+// not a string
+func (c *Context) GetString(key string) string {
+	s, _ := c.GetStringOK(key)
+	return s
+}
+
+// GetStringOK returns the string stored under key and whether there is one,
+// telling a missing key from a stored ""
+func (c *Context) GetStringOK(key string) (string, bool) {
+	val, _ := c.Get(key)
+	s, ok := val.(string)
+	return s, ok
+}
+
+// GetInt returns the integer stored under key, or 0 if it is absent or not
+// an integer. Any signed or unsigned integer type is accepted.
+func (c *Context) GetInt(key string) int {
+	i, _ := c.GetIntOK(key)
+	return i
+}
+
+// GetIntOK returns the integer stored under key and whether there is one,
+// telling a missing key from a stored 0
+func (c *Context) GetIntOK(key string) (int, bool) {
+	val, _ := c.Get(key)
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// GetBool returns the bool stored under key, or false if it is absent or
+// not a bool
+func (c *Context) GetBool(key string) bool {
+	b, _ := c.GetBoolOK(key)
+	return b
+}
+
+// GetBoolOK returns the bool stored under key and whether there is one,
+// telling a missing key from a stored false
+func (c *Context) GetBoolOK(key string) (bool, bool) {
+	val, _ := c.Get(key)
+	b, ok := val.(bool)
+	return b, ok
+}
@@ -0,0 +1,275 @@
+// Package auth is an optional user system in the spirit of Django's
+// contrib.auth: a User model, registration and login endpoints that issue
+// JWTs, and middleware that loads the signed-in user into the context.
+// Nothing is created unless Enable is called.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gojango"
+	"gojango/database"
+	"gojango/middleware"
+	"gojango/models"
+)
+
+// UserKey is the context key RequireAuth stores the *User under, where
+// middleware.RequireRole and RequirePermission look for it
+const UserKey = middleware.UserKey
+
+// User is the account model stored in the auth_users table
+type User struct {
+	models.Model
+	Username string `json:"username" db:"username,unique,not_null,size:150"`
+	Email    string `json:"email" db:"email,unique,not_null,size:255"`
+	Password string `json:"-" db:"password,not_null,size:255,hashed"`
+	Active   bool   `json:"active" db:"active,default:true"`
+	Staff    bool   `json:"staff" db:"staff,default:false"`
+	// Roles and Permissions are checked by middleware.RequireRole and
+	// RequirePermission; staff users also have the "staff" role
+	Roles       []string `json:"roles" db:"roles"`
+	Permissions []string `json:"permissions" db:"permissions"`
+}
+
+// TableName returns the table users are stored in
+func (u *User) TableName() string {
+	return "auth_users"
+}
+
+// HasRole reports whether the user has role
+func (u *User) HasRole(role string) bool {
+	if role == "staff" && u.Staff {
+		return true
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the user was granted permission
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth issues and checks tokens for the users of one app
+type Auth struct {
+	app               *gojango.App
+	secret            []byte
+	tokenTTL          time.Duration
+	minPasswordLength int
+}
+
+// Option configures Enable
+type Option func(*Auth)
+
+// WithSecret sets the key tokens are signed with, instead of the
+// "auth.secret" config setting
+func WithSecret(secret []byte) Option {
+	return func(a *Auth) {
+		a.secret = secret
+	}
+}
+
+// WithTokenTTL sets how long issued tokens are valid (default 24h)
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(a *Auth) {
+		a.tokenTTL = ttl
+	}
+}
+
+// WithMinPasswordLength sets the shortest password register accepts (default 8)
+func WithMinPasswordLength(n int) Option {
+	return func(a *Auth) {
+		a.minPasswordLength = n
+	}
+}
+
+// Enable migrates the User table and registers the auth endpoints under prefix:
+//
+//	POST prefix/register  {"username", "email", "password"}  201 {"token", "user"}
+//	POST prefix/login     {"username" or "email", "password"}  {"token", "user"}
+//	GET  prefix/me        the signed-in user (send "Authorization: Bearer <token>")
+//
+// Tokens are HS256 JWTs signed with the "auth.secret" setting or WithSecret;
+// Enable fails without one.
+func Enable(app *gojango.App, prefix string, opts ...Option) (*Auth, error) {
+	a := &Auth{
+		app:               app,
+		secret:            []byte(app.GetConfig().GetString("auth.secret", "")),
+		tokenTTL:          24 * time.Hour,
+		minPasswordLength: 8,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if len(a.secret) == 0 {
+		return nil, fmt.Errorf("auth: no signing secret (set auth.secret or use WithSecret)")
+	}
+
+	if err := app.AutoMigrate(&User{}); err != nil {
+		return nil, err
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	app.POST(prefix+"/register", a.register)
+	app.POST(prefix+"/login", a.login)
+	app.GET(prefix+"/me", func(c *gojango.Context) error {
+		user, _ := CurrentUser(c)
+		return c.JSON(user)
+	}, a.RequireAuth())
+
+	return a, nil
+}
+
+// credentials is the body of register and login requests
+type credentials struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// register creates an active user and signs them in
+func (a *Auth) register(c *gojango.Context) error {
+	var body credentials
+	if err := c.BindJSON(&body); err != nil {
+		return c.ErrorJSON(400, "Invalid JSON", err)
+	}
+
+	body.Username = strings.TrimSpace(body.Username)
+	body.Email = strings.TrimSpace(body.Email)
+
+	var errs models.ValidationErrors
+	if body.Username == "" {
+		errs = append(errs, models.ValidationError{Field: "username", Message: "is required"})
+	}
+	if !strings.Contains(body.Email, "@") {
+		errs = append(errs, models.ValidationError{Field: "email", Message: "must be a valid email address"})
+	}
+	if len(body.Password) < a.minPasswordLength {
+		errs = append(errs, models.ValidationError{Field: "password", Message: fmt.Sprintf("must be at least %d characters", a.minPasswordLength)})
+	}
+	if len(errs) > 0 {
+		return c.ErrorJSON(400, "Validation failed", errs)
+	}
+
+	users := a.app.NewQuerySet(&User{}).WithContext(c.Request.Context())
+	for _, unique := range [][2]string{{"username", body.Username}, {"email", body.Email}} {
+		field, value := unique[0], unique[1]
+		taken, err := users.Filter(field, value).Exists()
+		if err != nil {
+			return c.ErrorJSON(500, "Database error", err)
+		}
+		if taken {
+			return c.ErrorJSON(409, "Conflict", fmt.Errorf("%s is already registered", field))
+		}
+	}
+
+	user := &User{Username: body.Username, Email: body.Email, Password: body.Password, Active: true}
+	if err := a.app.GetDB().CreateContext(c.Request.Context(), user); err != nil {
+		return c.ErrorJSON(500, "Database error", err)
+	}
+
+	return a.respondWithToken(c, 201, user)
+}
+
+// login checks a username (or email) and password and issues a token
+func (a *Auth) login(c *gojango.Context) error {
+	var body credentials
+	if err := c.BindJSON(&body); err != nil {
+		return c.ErrorJSON(400, "Invalid JSON", err)
+	}
+
+	users := a.app.NewQuerySet(&User{}).WithContext(c.Request.Context())
+	if body.Username != "" {
+		users = users.Filter("username", body.Username)
+	} else {
+		users = users.Filter("email", body.Email)
+	}
+
+	results, err := users.Limit(1).All()
+	if err != nil {
+		return c.ErrorJSON(500, "Database error", err)
+	}
+
+	found, _ := results.([]*User)
+	if len(found) == 0 || !found[0].Active || !models.CheckPassword(found[0].Password, body.Password) {
+		return c.ErrorJSON(401, "Invalid credentials", nil)
+	}
+
+	return a.respondWithToken(c, 200, found[0])
+}
+
+// respondWithToken answers with a fresh token for user
+func (a *Auth) respondWithToken(c *gojango.Context, status int, user *User) error {
+	token, err := a.Token(user)
+	if err != nil {
+		return c.ErrorJSON(500, "Failed to issue token", err)
+	}
+	return c.JSONStatus(status, map[string]interface{}{"token": token, "user": user})
+}
+
+// Token issues a token for user, e.g. after a custom sign-in flow
+func (a *Auth) Token(user *User) (string, error) {
+	now := time.Now()
+	return middleware.SignJWT(middleware.Claims{
+		"sub":      strconv.FormatUint(uint64(user.ID), 10),
+		"username": user.Username,
+		"iat":      now.Unix(),
+		"exp":      now.Add(a.tokenTTL).Unix(),
+	}, a.secret)
+}
+
+// RequireAuth returns middleware that verifies the bearer token with the
+// JWT middleware, loads the user it was issued for and stores them under
+// UserKey. Requests without a valid token, or for a missing or inactive
+// user, get a 401.
+func (a *Auth) RequireAuth() gojango.Middleware {
+	verify := middleware.JWT(a.secret)
+
+	return func(c *gojango.Context) error {
+		if err := verify(c); err != nil || c.IsAborted() {
+			return err
+		}
+		claims, _ := c.MustGet(middleware.ClaimsKey).(middleware.Claims)
+
+		user := &User{}
+		if err := a.app.GetDB().FindByIDContext(c.Request.Context(), user, claims.Subject()); err != nil {
+			c.Abort()
+			if errors.Is(err, database.ErrNotFound) {
+				return c.ErrorJSON(401, "Unauthorized", nil)
+			}
+			return c.ErrorJSON(500, "Database error", err)
+		}
+
+		if !user.Active {
+			c.Abort()
+			return c.ErrorJSON(401, "Unauthorized", fmt.Errorf("account is disabled"))
+		}
+
+		c.Set(UserKey, user)
+		return nil
+	}
+}
+
+// CurrentUser returns the user RequireAuth loaded for this request
+func CurrentUser(c *gojango.Context) (*User, bool) {
+	value, ok := c.Get(UserKey)
+	if !ok {
+		return nil, false
+	}
+	user, ok := value.(*User)
+	return user, ok
+}
@@ -1,16 +1,32 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"log"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"gojango/models"
+	"gojango/signals"
 )
 
+// ErrNotFound is returned (wrapped) when a lookup by ID matches no record
+var ErrNotFound = errors.New("record not found")
+
+// ErrUnsupportedURL is returned by Connect for database URLs it can't handle
+var ErrUnsupportedURL = errors.New("unsupported database URL")
+
+// ErrStop can be returned from an Each callback to end iteration early
+// without Each reporting an error
+var ErrStop = errors.New("stop iteration")
+
 // MockDB is a simple in-memory database for testing
 type MockDB struct {
 	tables map[string][]map[string]interface{}
@@ -23,10 +39,143 @@ type DB struct {
 	Conn   *sql.DB // Exported for external access
 	driver string
 	mock   *MockDB // For testing without CGO
+
+	loggerMu sync.RWMutex
+	logger   QueryLogger
+
+	// relations maps a table to the migrated foreign keys referencing it
+	relationsMu sync.RWMutex
+	relations   map[string][]foreignKey
+
+	// replicas serve reads in turn; see AddReplica
+	replicas    []*sql.DB
+	nextReplica uint32
+
+	// tx is set on the DB passed to a Transaction callback, root is the DB
+	// the transaction was started on and depth its savepoint nesting level
+	tx    *sql.Tx
+	root  *DB
+	depth int
+}
+
+// QueryLogger receives every statement the DB runs, with its arguments,
+// how long it took and the error it returned (if any)
+type QueryLogger func(query string, args []interface{}, duration time.Duration, err error)
+
+// StdLogger is a QueryLogger that prints statements to the standard logger
+func StdLogger(query string, args []interface{}, duration time.Duration, err error) {
+	if err != nil {
+		log.Printf("[SQL] %s %v (%v) error: %v", query, args, duration, err)
+		return
+	}
+	log.Printf("[SQL] %s %v (%v)", query, args, duration)
+}
+
+// SetLogger installs a hook invoked after every statement (nil disables logging)
+func (db *DB) SetLogger(logger QueryLogger) {
+	db = db.base()
+	db.loggerMu.Lock()
+	defer db.loggerMu.Unlock()
+	db.logger = logger
+}
+
+// logQuery reports a finished statement to the logger, if one is set
+func (db *DB) logQuery(query string, args []interface{}, start time.Time, err error) {
+	db = db.base()
+	db.loggerMu.RLock()
+	logger := db.logger
+	db.loggerMu.RUnlock()
+
+	if logger != nil {
+		logger(query, args, time.Since(start), err)
+	}
+}
+
+// ExecContext runs a statement that returns no rows, reporting it to the logger
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.writer().ExecContext(ctx, query, args...)
+	db.logQuery(query, args, start, err)
+	return result, err
+}
+
+// QueryContext runs a query that returns rows, reporting it to the logger.
+// With replicas configured it runs on one of them unless ctx comes from
+// UsePrimary.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.reader(ctx).QueryContext(ctx, query, args...)
+	db.logQuery(query, args, start, err)
+	return rows, err
+}
+
+// QueryRowContext runs a query expected to return at most one row, reporting it
+// to the logger. Errors are deferred to Scan, as with sql.DB. Like
+// QueryContext it reads from a replica when there are any.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.reader(ctx).QueryRowContext(ctx, query, args...)
+	db.logQuery(query, args, start, row.Err())
+	return row
+}
+
+// Default connection pool settings used by Connect
+const (
+	DefaultMaxOpenConns    = 25
+	DefaultMaxIdleConns    = 5
+	DefaultConnMaxLifetime = 5 * time.Minute
+)
+
+// Option configures a database connection
+type Option func(*options)
+
+// options holds connection pool and driver settings
+type options struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	busyTimeout     time.Duration
+}
+
+// WithMaxOpenConns sets the maximum number of open connections (0 means unlimited)
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections kept in the pool
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime sets how long a connection may be reused (0 means forever)
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithBusyTimeout sets how long SQLite waits on a locked database before failing
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
 }
 
 // Connect establishes database connection
-func Connect(databaseURL string) (*DB, error) {
+func Connect(databaseURL string, opts ...Option) (*DB, error) {
+	settings := options{
+		maxOpenConns:    DefaultMaxOpenConns,
+		maxIdleConns:    DefaultMaxIdleConns,
+		connMaxLifetime: DefaultConnMaxLifetime,
+	}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+
 	// Simple URL parsing - in production you'd want more robust parsing
 	var driver, dsn string
 
@@ -40,16 +189,29 @@ func Connect(databaseURL string) (*DB, error) {
 				dsn = ":memory:"
 			}
 		}
+
+		if settings.busyTimeout > 0 {
+			separator := "?"
+			if strings.Contains(dsn, "?") {
+				separator = "&"
+			}
+			dsn += fmt.Sprintf("%s_busy_timeout=%d", separator, settings.busyTimeout.Milliseconds())
+		}
 	} else {
-		return nil, fmt.Errorf("unsupported database URL: %s", databaseURL)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedURL, databaseURL)
 	}
 
-	conn, err := sql.Open(driver, dsn)
+	conn, err := sql.Open(sqliteDriverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
+	conn.SetMaxOpenConns(settings.maxOpenConns)
+	conn.SetMaxIdleConns(settings.maxIdleConns)
+	conn.SetConnMaxLifetime(settings.connMaxLifetime)
+
 	if err := conn.Ping(); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
 
@@ -59,6 +221,43 @@ func Connect(databaseURL string) (*DB, error) {
 	}, nil
 }
 
+// ConnectWithRetry is Connect for databases that may not be ready yet, such
+// as a container starting alongside the app: a failed attempt is logged and
+// retried after backoff, which doubles each time, up to attempts tries in
+// total. The last error is returned if all of them fail. Unsupported URLs
+// fail at once.
+func ConnectWithRetry(databaseURL string, attempts int, backoff time.Duration, opts ...Option) (*DB, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		db, err := Connect(databaseURL, opts...)
+		if err == nil || errors.Is(err, ErrUnsupportedURL) {
+			return db, err
+		}
+		if attempt == attempts {
+			return nil, err
+		}
+
+		log.Printf("Database connection attempt %d/%d failed: %v; retrying in %v", attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Driver returns the name of the database driver: "sqlite3", or "mock" for
+// ConnectMock
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+// Mock returns the in-memory database of a ConnectMock connection, or nil
+// for a real one
+func (db *DB) Mock() *MockDB {
+	return db.base().mock
+}
+
 // ConnectMock creates a mock database connection for testing
 func ConnectMock() (*DB, error) {
 	return &DB{
@@ -73,17 +272,100 @@ func ConnectMock() (*DB, error) {
 
 // AutoMigrate creates/updates table schema for the given model
 func (db *DB) AutoMigrate(model interface{}) error {
+	_, err := db.MigrateModel(model)
+	return err
+}
+
+// MigrateModel creates the table for a model if it doesn't exist, or adds any
+// columns the model declares that the existing table lacks. It returns the
+// names of the columns that were added. Columns are never dropped or renamed.
+func (db *DB) MigrateModel(model interface{}) ([]string, error) {
 	// Use mock database if available
 	if db.mock != nil {
-		return db.mock.AutoMigrate(model)
+		return nil, db.mock.AutoMigrate(model)
 	}
 
-	modelValue := reflect.ValueOf(model)
+	tableName, fields, steps, err := db.planMigration(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.registerForeignKeys(tableName, fields); err != nil {
+		return nil, err
+	}
+
+	relations, err := db.manyToManyFields(model)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range relations {
+		db.setRelations(rel.joinTable, rel.foreignKeys())
+	}
+
+	var added []string
+
+	for _, step := range steps {
+		if _, err := db.ExecContext(context.Background(), step.sql); err != nil {
+			if step.table != "" {
+				return added, fmt.Errorf("failed to create table %s: %v", step.table, err)
+			}
+			if step.column == "" {
+				return nil, fmt.Errorf("failed to create table %s: %v", tableName, err)
+			}
+			return added, fmt.Errorf("failed to add column %s to %s: %v", step.column, tableName, err)
+		}
+
+		if step.column != "" {
+			added = append(added, step.column)
+		}
+	}
+
+	for _, indexSQL := range db.buildIndexes(tableName, fields) {
+		if _, err := db.ExecContext(context.Background(), indexSQL); err != nil {
+			return added, fmt.Errorf("failed to create index on %s: %v", tableName, err)
+		}
+	}
+
+	return added, nil
+}
+
+// PlanMigration returns the statements MigrateModel would run for a model
+// without running them: a CREATE TABLE when the table doesn't exist, or an
+// ALTER TABLE ... ADD COLUMN for each column it lacks. An empty result means
+// the table is up to date. Indexes are left out; migrations create them with
+// IF NOT EXISTS every time.
+func (db *DB) PlanMigration(model interface{}) ([]string, error) {
+	if db.mock != nil {
+		return nil, fmt.Errorf("PlanMigration is not supported by the mock database")
+	}
+
+	_, _, steps, err := db.planMigration(model)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, len(steps))
+	for i, step := range steps {
+		statements[i] = step.sql
+	}
+	return statements, nil
+}
+
+// migrationStep is a schema change; column is set for ADD COLUMN steps and
+// table for the join tables of many-to-many fields
+type migrationStep struct {
+	sql    string
+	column string
+	table  string
+}
+
+// planMigration compares a model with its table and returns the table name,
+// the model's fields and the steps that bring the table up to date
+func (db *DB) planMigration(model interface{}) (string, []fieldInfo, []migrationStep, error) {
 	modelType := reflect.TypeOf(model)
 
 	// Handle pointer types
 	if modelType.Kind() == reflect.Ptr {
-		modelValue = modelValue.Elem()
 		modelType = modelType.Elem()
 	}
 
@@ -93,55 +375,201 @@ func (db *DB) AutoMigrate(model interface{}) error {
 		tableName = strings.ToLower(modelType.Name()) + "s"
 	}
 
-	// Build CREATE TABLE statement
-	var columns []string
+	fields := modelFields(modelType)
 
-	for i := 0; i < modelType.NumField(); i++ {
-		field := modelType.Field(i)
+	existing, err := db.tableColumns(tableName)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to inspect table %s: %v", tableName, err)
+	}
 
-		// Skip unexported fields
-		if !field.IsExported() {
-			continue
+	var steps []migrationStep
+
+	// A composite key is declared as a table constraint, not per column
+	keys := primaryKeyFields(model)
+	inlineKey := len(keys) < 2
+
+	if len(existing) == 0 {
+		// Build CREATE TABLE statement
+		var columns []string
+
+		for _, field := range fields {
+			columnDef := db.buildColumnDefinition(field, inlineKey)
+			if columnDef != "" {
+				columns = append(columns, columnDef)
+			}
 		}
 
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" || dbTag == "-" {
-			continue
+		if len(columns) == 0 {
+			return "", nil, nil, fmt.Errorf("no database columns found for model %T", model)
+		}
+
+		if !inlineKey {
+			names := make([]string, len(keys))
+			for i, key := range keys {
+				names[i] = key.Column
+			}
+			columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(names, ", ")))
 		}
 
-		columnDef := db.buildColumnDefinition(field, dbTag)
-		if columnDef != "" {
-			columns = append(columns, columnDef)
+		createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)",
+			tableName, strings.Join(columns, ",\n  "))
+		steps = append(steps, migrationStep{sql: createSQL})
+	} else {
+		// Add columns for fields the table doesn't have yet
+		for _, field := range fields {
+			if existing[strings.ToLower(field.Column)] {
+				continue
+			}
+
+			columnDef := db.buildColumnDefinition(field, inlineKey)
+			alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", tableName, columnDef)
+			steps = append(steps, migrationStep{sql: alterSQL, column: field.Column})
 		}
 	}
 
-	if len(columns) == 0 {
-		return fmt.Errorf("no database columns found for model %T", model)
+	relations, err := db.manyToManyFields(model)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for _, rel := range relations {
+		joinColumns, err := db.tableColumns(rel.joinTable)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("failed to inspect table %s: %v", rel.joinTable, err)
+		}
+		if len(joinColumns) == 0 {
+			steps = append(steps, migrationStep{sql: rel.joinTableSQL(), table: rel.joinTable})
+		}
 	}
 
-	createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)",
-		tableName, strings.Join(columns, ",\n  "))
+	return tableName, fields, steps, nil
+}
+
+// tableColumns returns the lowercased column names of an existing table, or
+// an empty set when the table doesn't exist
+func (db *DB) tableColumns(tableName string) (map[string]bool, error) {
+	var rows *sql.Rows
+	var err error
 
-	_, err := db.Conn.Exec(createSQL)
+	if db.driver == "sqlite3" {
+		rows, err = db.QueryContext(UsePrimary(context.Background()), fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	} else {
+		rows, err = db.QueryContext(UsePrimary(context.Background()), "SELECT column_name FROM information_schema.columns WHERE table_name = ?", tableName)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create table %s: %v", tableName, err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			var value interface{}
+			values[i] = &value
+		}
+
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		// PRAGMA table_info returns (cid, name, ...); information_schema returns (column_name)
+		nameIndex := 0
+		if db.driver == "sqlite3" {
+			nameIndex = 1
+		}
+
+		name := fmt.Sprintf("%s", *(values[nameIndex].(*interface{})))
+		existing[strings.ToLower(name)] = true
+	}
+
+	return existing, rows.Err()
 }
 
-// buildColumnDefinition creates column definition from field and tag
-func (db *DB) buildColumnDefinition(field reflect.StructField, dbTag string) string {
-	parts := strings.Split(dbTag, ",")
+// buildIndexes creates CREATE INDEX statements from index tag options.
+// `index` and `unique_index` index a single column, while `index:name` and
+// `unique_index:name` group every column sharing the name into one composite
+// index, in field declaration order.
+func (db *DB) buildIndexes(tableName string, fields []fieldInfo) []string {
+	type index struct {
+		name    string
+		unique  bool
+		columns []string
+	}
+
+	var indexes []*index
+	byName := make(map[string]*index)
+
+	for _, field := range fields {
+		for _, option := range field.Options {
+			var unique bool
+			var group string
+
+			switch {
+			case option == "index":
+			case option == "unique_index":
+				unique = true
+			case strings.HasPrefix(option, "index:"):
+				group = strings.TrimPrefix(option, "index:")
+			case strings.HasPrefix(option, "unique_index:"):
+				unique = true
+				group = strings.TrimPrefix(option, "unique_index:")
+			default:
+				continue
+			}
+
+			name := "idx_" + tableName + "_" + field.Column
+			if group != "" {
+				name = "idx_" + tableName + "_" + group
+			}
+			if unique {
+				name = "u" + name
+			}
+
+			idx, exists := byName[name]
+			if !exists {
+				idx = &index{name: name, unique: unique}
+				byName[name] = idx
+				indexes = append(indexes, idx)
+			}
+			idx.columns = append(idx.columns, field.Column)
+		}
+	}
+
+	statements := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		create := "CREATE INDEX"
+		if idx.unique {
+			create = "CREATE UNIQUE INDEX"
+		}
+		statements = append(statements, fmt.Sprintf("%s IF NOT EXISTS %s ON %s (%s)",
+			create, idx.name, tableName, strings.Join(idx.columns, ", ")))
+	}
+
+	return statements
+}
+
+// buildColumnDefinition creates column definition from field and tag. A
+// primary_key field gets an inline PRIMARY KEY only when inlineKey is set;
+// composite keys are declared by the caller instead.
+func (db *DB) buildColumnDefinition(info fieldInfo, inlineKey bool) string {
+	field := info.Field
+	parts := strings.Split(field.Tag.Get("db"), ",")
 	columnName := parts[0]
 
 	if columnName == "" {
 		return ""
 	}
 
-	// Determine column type based on Go type
+	// Determine column type based on Go type; pointers and sql.Null types
+	// are nullable columns of the type they hold
+	valueType := info.valueType()
 	var columnType string
-	switch field.Type.Kind() {
+	switch valueType.Kind() {
 	case reflect.String:
 		columnType = "TEXT"
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
@@ -152,13 +580,13 @@ func (db *DB) buildColumnDefinition(field reflect.StructField, dbTag string) str
 	case reflect.Bool:
 		columnType = "BOOLEAN"
 	case reflect.Slice:
-		if field.Type.Elem().Kind() == reflect.Uint8 {
+		if valueType.Elem().Kind() == reflect.Uint8 {
 			columnType = "BLOB"
 		} else {
 			columnType = "TEXT"
 		}
 	default:
-		if field.Type == reflect.TypeOf(time.Time{}) {
+		if valueType == timeType {
 			columnType = "DATETIME"
 		} else {
 			columnType = "TEXT"
@@ -172,11 +600,16 @@ func (db *DB) buildColumnDefinition(field reflect.StructField, dbTag string) str
 		part = strings.TrimSpace(part)
 		switch {
 		case part == "primary_key":
-			constraints = append(constraints, "PRIMARY KEY")
+			if inlineKey {
+				constraints = append(constraints, "PRIMARY KEY")
+			}
 		case part == "auto_increment":
 			constraints = append(constraints, "AUTOINCREMENT")
 		case part == "not_null":
-			constraints = append(constraints, "NOT NULL")
+			// Optional fields must be able to store NULL
+			if !info.nullable() {
+				constraints = append(constraints, "NOT NULL")
+			}
 		case part == "unique":
 			constraints = append(constraints, "UNIQUE")
 		case strings.HasPrefix(part, "default:"):
@@ -189,7 +622,29 @@ func (db *DB) buildColumnDefinition(field reflect.StructField, dbTag string) str
 			}
 		case strings.HasPrefix(part, "type:"):
 			columnType = strings.TrimPrefix(part, "type:")
+		case strings.HasPrefix(part, "fk:"):
+			// on_delete rules are applied by DeleteWhere, not the database
+			if fk, ok, err := info.foreignKey(""); err == nil && ok {
+				constraints = append(constraints, fmt.Sprintf("REFERENCES %s(%s)", fk.refTable, fk.refColumn))
+			}
+		}
+	}
+
+	// JSON is stored as TEXT on SQLite, whose JSON functions operate on text
+	if info.isJSON() {
+		columnType = "JSON"
+		if db.driver == "sqlite3" {
+			columnType = "TEXT"
+		}
+	}
+
+	// Restrict the column to its declared choices
+	if choices := field.Tag.Get("choices"); choices != "" {
+		quoted := strings.Split(choices, "|")
+		for i, choice := range quoted {
+			quoted[i] = "'" + strings.ReplaceAll(choice, "'", "''") + "'"
 		}
+		constraints = append(constraints, fmt.Sprintf("CHECK (%s IN (%s))", columnName, strings.Join(quoted, ", ")))
 	}
 
 	definition := fmt.Sprintf("%s %s", columnName, columnType)
@@ -207,8 +662,11 @@ func (db *DB) GetTableName(model interface{}) string {
 
 // getTableName extracts table name from model
 func (db *DB) getTableName(model interface{}) string {
+	// An empty name (models.Model's default) means "derive it from the type"
 	if tableNamer, ok := model.(interface{ TableName() string }); ok {
-		return tableNamer.TableName()
+		if name := tableNamer.TableName(); name != "" {
+			return name
+		}
 	}
 
 	modelType := reflect.TypeOf(model)
@@ -221,63 +679,61 @@ func (db *DB) getTableName(model interface{}) string {
 
 // Create inserts a new record
 func (db *DB) Create(model interface{}) error {
-	// Use mock database if available
-	if db.mock != nil {
-		return db.mock.Create(model)
-	}
+	return db.CreateContext(context.Background(), model)
+}
 
+// CreateContext inserts a new record, aborting if ctx is cancelled. A
+// BeforeCreate method on the model runs first, and AfterCreate once the row
+// is written and its auto-increment ID set. The signals.PreSave and PostSave
+// signals follow the hooks.
+func (db *DB) CreateContext(ctx context.Context, model interface{}) error {
 	// Call BeforeCreate hook if available
 	if beforeCreator, ok := model.(interface{ BeforeCreate() }); ok {
 		beforeCreator.BeforeCreate()
 	}
+	signals.Send(signals.Event{Signal: signals.PreSave, Model: model, Created: true, Context: ctx})
 
-	tableName := db.getTableName(model)
-
-	modelValue := reflect.ValueOf(model)
-	modelType := reflect.TypeOf(model)
-
-	if modelType.Kind() == reflect.Ptr {
-		modelValue = modelValue.Elem()
-		modelType = modelType.Elem()
+	if err := models.HashPasswords(model); err != nil {
+		return err
 	}
 
-	var columns []string
-	var placeholders []string
-	var values []interface{}
+	if err := db.insert(ctx, model); err != nil {
+		return err
+	}
 
-	for i := 0; i < modelType.NumField(); i++ {
-		field := modelType.Field(i)
-		fieldValue := modelValue.Field(i)
+	// Call AfterCreate hook if available
+	if afterCreator, ok := model.(interface{ AfterCreate() }); ok {
+		afterCreator.AfterCreate()
+	}
+	signals.Send(signals.Event{Signal: signals.PostSave, Model: model, Created: true, Context: ctx})
 
-		if !field.IsExported() {
-			continue
-		}
+	return nil
+}
 
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" || dbTag == "-" {
-			continue
+// insert writes a new record and sets its auto-increment key
+func (db *DB) insert(ctx context.Context, model interface{}) error {
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		return db.mock.Create(model)
+	}
 
-		columnName := strings.Split(dbTag, ",")[0]
-
-		// Skip auto-increment primary keys
-		if strings.Contains(dbTag, "auto_increment") {
-			continue
-		}
+	tableName := db.getTableName(model)
 
-		columns = append(columns, columnName)
-		placeholders = append(placeholders, "?")
-		values = append(values, fieldValue.Interface())
+	columns, values, defaulted, err := db.insertColumns(model)
+	if err != nil {
+		return err
 	}
-
 	if len(columns) == 0 {
 		return fmt.Errorf("no columns to insert for model %T", model)
 	}
 
 	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		tableName, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+		tableName, strings.Join(columns, ", "), placeholderList(len(columns)))
 
-	result, err := db.Conn.Exec(insertSQL, values...)
+	result, err := db.ExecContext(ctx, insertSQL, values...)
 	if err != nil {
 		return fmt.Errorf("failed to insert record: %v", err)
 	}
@@ -287,114 +743,752 @@ func (db *DB) Create(model interface{}) error {
 		db.setIDField(model, lastID)
 	}
 
+	if len(defaulted) > 0 {
+		return db.loadColumns(ctx, model, defaulted)
+	}
+	return nil
+}
+
+// insertColumns returns the columns and values Create writes for a model,
+// leaving out auto-increment keys so the database assigns them, and zero
+// use_db_default fields so it applies their default. defaulted lists the
+// latter.
+func (db *DB) insertColumns(model interface{}) (columns []string, values []interface{}, defaulted []string, err error) {
+	modelValue := reflect.ValueOf(model)
+	if modelValue.Kind() == reflect.Ptr {
+		modelValue = modelValue.Elem()
+	}
+
+	for _, field := range modelFields(modelValue.Type()) {
+		// Skip auto-increment primary keys
+		if field.has("auto_increment") {
+			continue
+		}
+
+		fieldValue := modelValue.FieldByIndex(field.Index)
+		if field.usesDBDefault(fieldValue) {
+			defaulted = append(defaulted, field.Column)
+			continue
+		}
+
+		value, err := field.columnValue(fieldValue)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		columns = append(columns, field.Column)
+		values = append(values, value)
+	}
+
+	return columns, values, defaulted, nil
+}
+
+// loadColumns reads the named columns of model's just-inserted row back into
+// it, so it holds the defaults the database filled in. Models without a
+// primary key can't be found again and are left as they are.
+func (db *DB) loadColumns(ctx context.Context, model interface{}, columns []string) error {
+	keys := primaryKeyFields(model)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	elem := reflect.ValueOf(model).Elem()
+	keyNames := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		keyNames[i] = key.Column
+		args[i] = elem.FieldByIndex(key.Index).Interface()
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(columns, ", "), db.getTableName(model), keyWhere(keyNames))
+	rows, err := db.QueryContext(UsePrimary(ctx), query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read column defaults: %v", err)
+	}
+	defer rows.Close()
+
+	if err := db.scanRow(rows, model); err != nil {
+		return fmt.Errorf("failed to read column defaults: %v", err)
+	}
 	return nil
 }
 
+// placeholderList returns n comma-separated "?" placeholders
+func placeholderList(n int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	return strings.Join(placeholders, ", ")
+}
+
 // FindAll retrieves all records of a model type
 func (db *DB) FindAll(model interface{}) (interface{}, error) {
+	return db.FindAllContext(context.Background(), model)
+}
+
+// FindAllContext retrieves all records of a model type, aborting if ctx is cancelled
+func (db *DB) FindAllContext(ctx context.Context, model interface{}) (interface{}, error) {
 	// Use mock database if available
 	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		return db.mock.FindAll(model)
 	}
 
 	tableName := db.getTableName(model)
 
 	selectSQL := fmt.Sprintf("SELECT * FROM %s", tableName)
-	rows, err := db.Conn.Query(selectSQL)
+	rows, err := db.QueryContext(ctx, selectSQL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query records: %v", err)
 	}
-	defer rows.Close()
+	defer rows.Close()
+
+	return db.scanRows(rows, model)
+}
+
+// Find retrieves the records of a model type whose columns equal the values
+// in conditions, as a slice of model pointers like FindAll:
+//
+//	users, err := db.Find(&User{}, map[string]interface{}{"active": true, "role": "admin"})
+//
+// A nil value matches NULL. Columns are ANDed together in sorted order, and
+// one the model doesn't map is an error. For other lookups, use a QuerySet.
+func (db *DB) Find(model interface{}, conditions map[string]interface{}) (interface{}, error) {
+	return db.FindContext(context.Background(), model, conditions)
+}
+
+// FindContext is Find, aborting if ctx is cancelled
+func (db *DB) FindContext(ctx context.Context, model interface{}, conditions map[string]interface{}) (interface{}, error) {
+	columns := make([]string, 0, len(conditions))
+	for column := range conditions {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	known := make(map[string]bool)
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		known[field.Column] = true
+	}
+	for _, column := range columns {
+		if !known[column] {
+			return nil, fmt.Errorf("%T has no column %q", model, column)
+		}
+	}
+
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return db.mock.find(model, func(record map[string]interface{}) bool {
+			for _, column := range columns {
+				stored, want := storedValue(record[column]), conditions[column]
+				if stored == nil || want == nil {
+					if stored != nil || want != nil {
+						return false
+					}
+					continue
+				}
+				if fmt.Sprintf("%v", stored) != fmt.Sprintf("%v", want) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	var where []string
+	var args []interface{}
+	for _, column := range columns {
+		if conditions[column] == nil {
+			where = append(where, column+" IS NULL")
+			continue
+		}
+		args = append(args, conditions[column])
+		where = append(where, column+" = ?")
+	}
+
+	selectSQL := fmt.Sprintf("SELECT * FROM %s", db.getTableName(model))
+	if len(where) > 0 {
+		selectSQL += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records: %v", err)
+	}
+	defer rows.Close()
+
+	return db.scanRows(rows, model)
+}
+
+// Each streams every record of a model type to fn, one freshly allocated
+// model pointer per row, without loading the whole table into memory.
+// Returning ErrStop from fn ends the iteration early; any other error aborts
+// it and is returned.
+func (db *DB) Each(model interface{}, fn func(row interface{}) error) error {
+	return db.EachContext(context.Background(), model, fn)
+}
+
+// EachContext is Each, aborting if ctx is cancelled
+func (db *DB) EachContext(ctx context.Context, model interface{}, fn func(row interface{}) error) error {
+	// Use mock database if available
+	if db.mock != nil {
+		results, err := db.FindAllContext(ctx, model)
+		if err != nil {
+			return err
+		}
+
+		resultsValue := reflect.ValueOf(results)
+		for i := 0; i < resultsValue.Len(); i++ {
+			if err := fn(resultsValue.Index(i).Interface()); err != nil {
+				if errors.Is(err, ErrStop) {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+
+	selectSQL := fmt.Sprintf("SELECT * FROM %s", db.getTableName(model))
+	rows, err := db.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return fmt.Errorf("failed to query records: %v", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		row := reflect.New(modelType).Interface()
+		if err := db.scanRowIntoModel(rows, columns, row); err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// FindByID finds a record by ID. The id may be a string or any numeric type;
+// for a model with a composite primary key, pass a map of each key column
+// to its value:
+//
+//	err := db.FindByID(&membership, map[string]interface{}{"user_id": 1, "group_id": 2})
+func (db *DB) FindByID(model interface{}, id interface{}) error {
+	return db.FindByIDContext(context.Background(), model, id)
+}
+
+// FindByIDContext finds a record by ID, aborting if ctx is cancelled
+func (db *DB) FindByIDContext(ctx context.Context, model interface{}, id interface{}) error {
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return db.mock.FindByID(model, id)
+	}
+
+	tableName := db.getTableName(model)
+
+	columns, args, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE %s", tableName, keyWhere(columns))
+	rows, err := db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query record: %v", err)
+	}
+	defer rows.Close()
+
+	if err := db.scanRow(rows, model); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s with id %v", ErrNotFound, tableName, id)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Exists reports whether a record of the model's type has value in the
+// column whereField:
+//
+//	taken, err := db.Exists(&User{}, "email", email)
+func (db *DB) Exists(model interface{}, whereField string, value interface{}) (bool, error) {
+	return db.ExistsContext(context.Background(), model, whereField, value)
+}
+
+// ExistsContext is Exists, aborting if ctx is cancelled
+func (db *DB) ExistsContext(ctx context.Context, model interface{}, whereField string, value interface{}) (bool, error) {
+	if !hasColumn(model, whereField) {
+		return false, fmt.Errorf("%s has no column %q", db.getTableName(model), whereField)
+	}
+
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		return db.mock.Exists(model, whereField, value), nil
+	}
+
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ? LIMIT 1", db.getTableName(model), whereField)
+	var found int
+	err := db.QueryRowContext(ctx, query, value).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query records: %v", err)
+	}
+	return true, nil
+}
+
+// Count returns the number of records of the model's type
+func (db *DB) Count(model interface{}) (int, error) {
+	return db.CountContext(context.Background(), model)
+}
+
+// CountContext is Count, aborting if ctx is cancelled
+func (db *DB) CountContext(ctx context.Context, model interface{}) (int, error) {
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return db.mock.Count(model), nil
+	}
+
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", db.getTableName(model))
+	if err := db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count records: %v", err)
+	}
+	return count, nil
+}
+
+// hasColumn reports whether column is mapped by a field of model
+func hasColumn(model interface{}, column string) bool {
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		if field.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Update updates a record by ID; see FindByID for composite keys
+func (db *DB) Update(model interface{}, id interface{}) error {
+	return db.UpdateContext(context.Background(), model, id)
+}
+
+// UpdateContext updates a record by ID, aborting if ctx is cancelled. The
+// model's BeforeUpdate and AfterUpdate methods, if any, run around the write,
+// each followed by the signals.PreSave or PostSave signal.
+func (db *DB) UpdateContext(ctx context.Context, model interface{}, id interface{}) error {
+	// Call BeforeUpdate hook if available
+	if beforeUpdater, ok := model.(interface{ BeforeUpdate() }); ok {
+		beforeUpdater.BeforeUpdate()
+	}
+	signals.Send(signals.Event{Signal: signals.PreSave, Model: model, ID: id, Context: ctx})
+
+	if err := models.HashPasswords(model); err != nil {
+		return err
+	}
+
+	if err := db.update(ctx, model, id, nil); err != nil {
+		return err
+	}
+
+	// Call AfterUpdate hook if available
+	if afterUpdater, ok := model.(interface{ AfterUpdate() }); ok {
+		afterUpdater.AfterUpdate()
+	}
+	signals.Send(signals.Event{Signal: signals.PostSave, Model: model, ID: id, Context: ctx})
+
+	return nil
+}
+
+// UpdateFields updates only the named columns of the record with id,
+// leaving the rest of the row as it is
+func (db *DB) UpdateFields(model interface{}, id interface{}, columns ...string) error {
+	return db.UpdateFieldsContext(context.Background(), model, id, columns...)
+}
+
+// UpdateFieldsContext is UpdateFields, aborting if ctx is cancelled. The
+// hooks run as for UpdateContext, and the updated_at column that
+// models.Model's BeforeUpdate sets is written along with columns. Unknown
+// and primary key columns are an error. The mock database writes the
+// whole model.
+func (db *DB) UpdateFieldsContext(ctx context.Context, model interface{}, id interface{}, columns ...string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns to update for model %T", model)
+	}
+
+	known := make(map[string]fieldInfo)
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		known[field.Column] = field
+	}
+
+	selected := make(map[string]bool, len(columns)+1)
+	for _, column := range columns {
+		field, ok := known[column]
+		if !ok {
+			return fmt.Errorf("%s has no column %q", db.getTableName(model), column)
+		}
+		if field.has("primary_key") || field.has("auto_increment") {
+			return fmt.Errorf("cannot update primary key column %q", column)
+		}
+		selected[column] = true
+	}
+	if _, ok := known["updated_at"]; ok {
+		selected["updated_at"] = true
+	}
+
+	if beforeUpdater, ok := model.(interface{ BeforeUpdate() }); ok {
+		beforeUpdater.BeforeUpdate()
+	}
+	signals.Send(signals.Event{Signal: signals.PreSave, Model: model, ID: id, Context: ctx})
+
+	if err := models.HashPasswords(model); err != nil {
+		return err
+	}
+
+	if err := db.update(ctx, model, id, selected); err != nil {
+		return err
+	}
+
+	if afterUpdater, ok := model.(interface{ AfterUpdate() }); ok {
+		afterUpdater.AfterUpdate()
+	}
+	signals.Send(signals.Event{Signal: signals.PostSave, Model: model, ID: id, Context: ctx})
+
+	return nil
+}
+
+// update writes the non-key columns of a model to the row with id: all of
+// them, or only those in columns when it is not nil
+func (db *DB) update(ctx context.Context, model interface{}, id interface{}, columns map[string]bool) error {
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return db.mock.Update(model, id)
+	}
+
+	tableName := db.getTableName(model)
+
+	keys, keyArgs, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
+	modelValue := reflect.ValueOf(model)
+	modelType := reflect.TypeOf(model)
+
+	if modelType.Kind() == reflect.Ptr {
+		modelValue = modelValue.Elem()
+		modelType = modelType.Elem()
+	}
+
+	var setParts []string
+	var values []interface{}
+
+	for _, field := range modelFields(modelType) {
+		// Skip primary key and auto-increment fields
+		if field.has("primary_key") || field.has("auto_increment") {
+			continue
+		}
+		if columns != nil && !columns[field.Column] {
+			continue
+		}
+
+		value, err := field.columnValue(modelValue.FieldByIndex(field.Index))
+		if err != nil {
+			return err
+		}
+
+		setParts = append(setParts, field.Column+" = ?")
+		values = append(values, value)
+	}
+
+	if len(setParts) == 0 {
+		return fmt.Errorf("no columns to update for model %T", model)
+	}
+
+	values = append(values, keyArgs...)
+	updateSQL := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		tableName, strings.Join(setParts, ", "), keyWhere(keys))
+
+	result, err := db.ExecContext(ctx, updateSQL, values...)
+	if err != nil {
+		return fmt.Errorf("failed to update record: %v", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: %s with id %v", ErrNotFound, tableName, id)
+	}
+
+	return nil
+}
+
+// Delete deletes a record by ID; see FindByID for composite keys
+func (db *DB) Delete(model interface{}, id interface{}) error {
+	return db.DeleteContext(context.Background(), model, id)
+}
+
+// DeleteContext deletes a record by ID, aborting if ctx is cancelled.
+// Related rows are handled by their on_delete rules; see DeleteWhereContext.
+// The BeforeDelete and AfterDelete hooks, and the signals.PreDelete and
+// PostDelete signals, get model as given; it is not loaded from the
+// database first.
+func (db *DB) DeleteContext(ctx context.Context, model interface{}, id interface{}) error {
+	// Call BeforeDelete hook if available
+	if beforeDeleter, ok := model.(interface{ BeforeDelete() }); ok {
+		beforeDeleter.BeforeDelete()
+	}
+	signals.Send(signals.Event{Signal: signals.PreDelete, Model: model, ID: id, Context: ctx})
+
+	if err := db.deleteByID(ctx, model, id); err != nil {
+		return err
+	}
+
+	// Call AfterDelete hook if available
+	if afterDeleter, ok := model.(interface{ AfterDelete() }); ok {
+		afterDeleter.AfterDelete()
+	}
+	signals.Send(signals.Event{Signal: signals.PostDelete, Model: model, ID: id, Context: ctx})
+
+	return nil
+}
+
+// deleteByID deletes the row with id from model's table
+func (db *DB) deleteByID(ctx context.Context, model interface{}, id interface{}) error {
+	// Use mock database if available
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return db.mock.Delete(model, id)
+	}
+
+	columns, args, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := db.DeleteWhereContext(ctx, model, keyWhere(columns), args...)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return fmt.Errorf("%w: %s with id %v", ErrNotFound, db.getTableName(model), id)
+	}
 
-	return db.scanRows(rows, model)
+	return nil
 }
 
-// FindByID finds a record by ID
-func (db *DB) FindByID(model interface{}, id string) error {
-	// Use mock database if available
-	if db.mock != nil {
-		return db.mock.FindByID(model, id)
-	}
-
-	tableName := db.getTableName(model)
+// UpsertResult reports what an Upsert did
+type UpsertResult int
 
-	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE id = ?", tableName)
-	row := db.Conn.QueryRow(selectSQL, id)
+const (
+	// UpsertUnknown accompanies an error; the row may not have been written
+	UpsertUnknown UpsertResult = iota
+	// UpsertInserted means a new row was created
+	UpsertInserted
+	// UpsertUpdated means an existing row matching the conflict columns was updated
+	UpsertUpdated
+)
 
-	return db.scanRow(row, model)
+// Upsert inserts a model, or updates the existing row whose conflictColumns
+// match it, using INSERT ... ON CONFLICT DO UPDATE so the write is atomic.
+// The conflict columns must be covered by a unique index or primary key.
+//
+// The matching row is looked up first: BeforeCreate and a PreSave with
+// Created set run when there is none, BeforeUpdate and a PreSave carrying its
+// key when there is, and AfterCreate or AfterUpdate and PostSave follow the
+// result. SQLite can't report what the write did, so there the result is that
+// lookup's, which a concurrent writer can overtake.
+func (db *DB) Upsert(model interface{}, conflictColumns []string) (UpsertResult, error) {
+	return db.UpsertContext(context.Background(), model, conflictColumns)
 }
 
-// Update updates a record by ID
-func (db *DB) Update(model interface{}, id string) error {
-	// Call BeforeUpdate hook if available
-	if beforeUpdater, ok := model.(interface{ BeforeUpdate() }); ok {
-		beforeUpdater.BeforeUpdate()
+// UpsertContext is Upsert, aborting if ctx is cancelled
+func (db *DB) UpsertContext(ctx context.Context, model interface{}, conflictColumns []string) (UpsertResult, error) {
+	if len(conflictColumns) == 0 {
+		return UpsertUnknown, fmt.Errorf("upsert requires at least one conflict column")
 	}
 
-	tableName := db.getTableName(model)
-
-	modelValue := reflect.ValueOf(model)
-	modelType := reflect.TypeOf(model)
+	existing, err := db.upsertTarget(ctx, model, conflictColumns)
+	if err != nil {
+		return UpsertUnknown, err
+	}
 
-	if modelType.Kind() == reflect.Ptr {
-		modelValue = modelValue.Elem()
-		modelType = modelType.Elem()
+	var existingID interface{}
+	if existing != nil {
+		existingID = modelKey(existing)
+		if beforeUpdater, ok := model.(interface{ BeforeUpdate() }); ok {
+			beforeUpdater.BeforeUpdate()
+		}
+	} else if beforeCreator, ok := model.(interface{ BeforeCreate() }); ok {
+		beforeCreator.BeforeCreate()
 	}
+	signals.Send(signals.Event{Signal: signals.PreSave, Model: model, ID: existingID, Created: existing == nil, Context: ctx})
 
-	var setParts []string
-	var values []interface{}
+	if err := models.HashPasswords(model); err != nil {
+		return UpsertUnknown, err
+	}
 
-	for i := 0; i < modelType.NumField(); i++ {
-		field := modelType.Field(i)
-		fieldValue := modelValue.Field(i)
+	var result UpsertResult
+	if db.mock != nil {
+		if err := ctx.Err(); err != nil {
+			return UpsertUnknown, err
+		}
+		result, err = db.mock.Upsert(model, conflictColumns)
+	} else {
+		err = db.upsert(ctx, model, conflictColumns)
+		result = UpsertInserted
+		if existing != nil {
+			result = UpsertUpdated
+		}
+	}
+	if err != nil {
+		return UpsertUnknown, err
+	}
 
-		if !field.IsExported() {
-			continue
+	if result == UpsertInserted {
+		if afterCreator, ok := model.(interface{ AfterCreate() }); ok {
+			afterCreator.AfterCreate()
 		}
+		signals.Send(signals.Event{Signal: signals.PostSave, Model: model, Created: true, Context: ctx})
+		return result, nil
+	}
 
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" || dbTag == "-" {
-			continue
+	if afterUpdater, ok := model.(interface{ AfterUpdate() }); ok {
+		afterUpdater.AfterUpdate()
+	}
+	signals.Send(signals.Event{Signal: signals.PostSave, Model: model, ID: modelKey(model), Context: ctx})
+	return result, nil
+}
+
+// upsertTarget returns the stored row an upsert of model would conflict
+// with, or nil if there is none
+func (db *DB) upsertTarget(ctx context.Context, model interface{}, conflictColumns []string) (interface{}, error) {
+	columns, values, _, err := db.insertColumns(model)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := make(map[string]interface{}, len(conflictColumns))
+	for i, column := range columns {
+		if contains(conflictColumns, column) {
+			conditions[column] = values[i]
 		}
+	}
+	// A conflict column the insert leaves to the database can't match a row
+	if len(conditions) != len(conflictColumns) {
+		return nil, nil
+	}
+
+	found, err := db.FindContext(UsePrimary(ctx), model, conditions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for existing record: %v", err)
+	}
+	rows := reflect.ValueOf(found)
+	if rows.Len() == 0 {
+		return nil, nil
+	}
+	return rows.Index(0).Interface(), nil
+}
 
-		columnName := strings.Split(dbTag, ",")[0]
+// upsert writes model with INSERT ... ON CONFLICT, reading back its key
+func (db *DB) upsert(ctx context.Context, model interface{}, conflictColumns []string) error {
+	columns, values, _, err := db.insertColumns(model)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns to insert for model %T", model)
+	}
 
-		// Skip primary key and auto-increment fields
-		if strings.Contains(dbTag, "primary_key") || strings.Contains(dbTag, "auto_increment") {
+	// Conflicting rows keep their key columns and original creation timestamp
+	var updates []string
+	for _, column := range columns {
+		if contains(conflictColumns, column) || column == "created_at" {
 			continue
 		}
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", column, column))
+	}
 
-		setParts = append(setParts, columnName+" = ?")
-		values = append(values, fieldValue.Interface())
+	action := "DO NOTHING"
+	if len(updates) > 0 {
+		action = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	upsertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		db.getTableName(model), strings.Join(columns, ", "), placeholderList(len(columns)),
+		strings.Join(conflictColumns, ", "), action)
+
+	pk, hasPK := primaryKeyField(model)
+	if !hasPK {
+		if _, err := db.ExecContext(ctx, upsertSQL, values...); err != nil {
+			return fmt.Errorf("failed to upsert record: %v", err)
+		}
+		return nil
 	}
 
-	if len(setParts) == 0 {
-		return fmt.Errorf("no columns to update for model %T", model)
+	// RETURNING yields the row's key whether it was inserted or updated
+	rows, err := db.QueryContext(UsePrimary(ctx), upsertSQL+" RETURNING "+pk.Column, values...)
+	if err != nil {
+		return fmt.Errorf("failed to upsert record: %v", err)
 	}
+	defer rows.Close()
 
-	values = append(values, id)
-	updateSQL := fmt.Sprintf("UPDATE %s SET %s WHERE id = ?",
-		tableName, strings.Join(setParts, ", "))
+	if rows.Next() {
+		var id interface{}
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to read upserted key: %v", err)
+		}
 
-	_, err := db.Conn.Exec(updateSQL, values...)
-	if err != nil {
-		return fmt.Errorf("failed to update record: %v", err)
+		keyValue := reflect.ValueOf(model).Elem().FieldByIndex(pk.Index)
+		if keyValue.CanSet() {
+			assignValue(keyValue, id)
+		}
 	}
 
-	return nil
+	return rows.Err()
 }
 
-// Delete deletes a record by ID
-func (db *DB) Delete(model interface{}, id string) error {
-	tableName := db.getTableName(model)
-
-	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE id = ?", tableName)
-	_, err := db.Conn.Exec(deleteSQL, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete record: %v", err)
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
 	}
-
-	return nil
+	return false
 }
 
 // setIDField sets the ID field of a model (helper for auto-increment)
@@ -409,16 +1503,14 @@ func (db *DB) setIDField(model interface{}, id int64) {
 	}
 
 	// Look for ID field
-	for i := 0; i < modelValue.NumField(); i++ {
-		field := modelValue.Type().Field(i)
-		fieldValue := modelValue.Field(i)
+	for _, field := range modelFields(modelValue.Type()) {
+		fieldValue := modelValue.FieldByIndex(field.Index)
 
 		if !fieldValue.CanSet() {
 			continue
 		}
 
-		dbTag := field.Tag.Get("db")
-		if strings.Contains(dbTag, "primary_key") && strings.Contains(dbTag, "auto_increment") {
+		if field.has("primary_key") && field.has("auto_increment") {
 			switch fieldValue.Kind() {
 			case reflect.Uint, reflect.Uint32, reflect.Uint64:
 				fieldValue.SetUint(uint64(id))
@@ -435,6 +1527,12 @@ func (db *DB) ScanRows(rows *sql.Rows, model interface{}) (interface{}, error) {
 	return db.scanRows(rows, model)
 }
 
+// ScanRow scans the first row of rows into model, a struct pointer, matching
+// columns by db tag. It returns sql.ErrNoRows when there are no rows.
+func (db *DB) ScanRow(rows *sql.Rows, model interface{}) error {
+	return db.scanRow(rows, model)
+}
+
 // scanRows scans multiple rows into a slice of models
 func (db *DB) scanRows(rows *sql.Rows, model interface{}) (interface{}, error) {
 	modelType := reflect.TypeOf(model)
@@ -463,81 +1561,99 @@ func (db *DB) scanRows(rows *sql.Rows, model interface{}) (interface{}, error) {
 	return results.Interface(), nil
 }
 
-// scanRow scans a single row into a model
-func (db *DB) scanRow(row *sql.Row, model interface{}) error {
-	// For single row, we need to get columns differently
-	// This is a simplified implementation
-	modelValue := reflect.ValueOf(model)
-	modelType := reflect.TypeOf(model)
-
-	if modelType.Kind() == reflect.Ptr {
-		modelValue = modelValue.Elem()
-		modelType = modelType.Elem()
-	}
-
-	var scanValues []interface{}
-
-	for i := 0; i < modelType.NumField(); i++ {
-		field := modelType.Field(i)
-		fieldValue := modelValue.Field(i)
-
-		if !field.IsExported() {
-			continue
-		}
-
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" || dbTag == "-" {
-			continue
+// scanRow scans the first row of a result set into a model, mapping values by
+// the column names the query actually returned. It returns sql.ErrNoRows when
+// the result set is empty.
+func (db *DB) scanRow(rows *sql.Rows, model interface{}) error {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
 		}
+		return sql.ErrNoRows
+	}
 
-		scanValues = append(scanValues, fieldValue.Addr().Interface())
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
 	}
 
-	return row.Scan(scanValues...)
+	return db.scanRowIntoModel(rows, columns, model)
 }
 
-// scanRowIntoModel scans a row into a model with column mapping
+// scanRowIntoModel scans a row into a model with column mapping. Values are
+// scanned into intermediates first so that datetimes SQLite hands back as
+// strings (and integers of any width) are converted to the field's type.
 func (db *DB) scanRowIntoModel(rows *sql.Rows, columns []string, model interface{}) error {
 	modelValue := reflect.ValueOf(model)
-	modelType := reflect.TypeOf(model)
-
-	if modelType.Kind() == reflect.Ptr {
+	if modelValue.Kind() == reflect.Ptr {
 		modelValue = modelValue.Elem()
-		modelType = modelType.Elem()
 	}
 
-	// Create a map of column names to field indices
-	columnMap := make(map[string]int)
-	for i := 0; i < modelType.NumField(); i++ {
-		field := modelType.Field(i)
-		dbTag := field.Tag.Get("db")
-		if dbTag != "" && dbTag != "-" {
-			columnName := strings.Split(dbTag, ",")[0]
-			columnMap[columnName] = i
-		}
+	// Create a map of column names to fields
+	columnMap := make(map[string]fieldInfo)
+	for _, field := range modelFields(modelValue.Type()) {
+		columnMap[field.Column] = field
 	}
 
 	// Prepare scan destinations
 	scanDests := make([]interface{}, len(columns))
+	for i := range columns {
+		var value interface{}
+		scanDests[i] = &value
+	}
+
+	if err := rows.Scan(scanDests...); err != nil {
+		return err
+	}
+
 	for i, column := range columns {
-		if fieldIndex, exists := columnMap[column]; exists {
-			fieldValue := modelValue.Field(fieldIndex)
-			scanDests[i] = fieldValue.Addr().Interface()
-		} else {
-			// Use a discard variable for unknown columns
-			var discard interface{}
-			scanDests[i] = &discard
+		field, exists := columnMap[column]
+		if !exists {
+			continue
+		}
+
+		fieldValue := modelValue.FieldByIndex(field.Index)
+		if err := field.assign(fieldValue, *(scanDests[i].(*interface{}))); err != nil {
+			return fmt.Errorf("failed to scan column %s: %v", column, err)
 		}
 	}
 
-	return rows.Scan(scanDests...)
+	return nil
 }
 
-// Close closes the database connection
+// Close closes the database connection and those of any replicas
 func (db *DB) Close() error {
+	if db.tx != nil {
+		return fmt.Errorf("cannot close the database from inside a transaction")
+	}
+	for _, replica := range db.replicas {
+		replica.Close()
+	}
+	if db.Conn == nil {
+		// Mock databases have no connection to close
+		return nil
+	}
 	return db.Conn.Close()
 }
 
+// Ping checks that the database, and each read replica, is reachable. It
+// always succeeds for the mock database.
+func (db *DB) Ping(ctx context.Context) error {
+	db = db.base()
+	if db.Conn == nil {
+		return nil
+	}
+	if err := db.Conn.PingContext(ctx); err != nil {
+		return err
+	}
+	for i, replica := range db.replicas {
+		if err := replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
 // MockAutoMigrate simulates table creation
 func (mdb *MockDB) AutoMigrate(model interface{}) error {
 	tableName := mdb.getTableName(model)
@@ -555,30 +1671,39 @@ func (mdb *MockDB) AutoMigrate(model interface{}) error {
 
 // MockCreate simulates record creation
 func (mdb *MockDB) Create(model interface{}) error {
-	tableName := mdb.getTableName(model)
-
 	mdb.mutex.Lock()
 	defer mdb.mutex.Unlock()
+	return mdb.insert(model)
+}
+
+// insert stores model as a new record; the caller holds the mutex
+func (mdb *MockDB) insert(model interface{}) error {
+	tableName := mdb.getTableName(model)
 
 	// Initialize table if it doesn't exist
 	if _, exists := mdb.tables[tableName]; !exists {
-		mdb.AutoMigrate(model)
+		mdb.tables[tableName] = make([]map[string]interface{}, 0)
+		mdb.nextID[tableName] = 1
 	}
 
+	// Stand in for the database's defaults
+	mdb.applyDefaults(model)
+
 	// Convert model to map
 	record := mdb.modelToMap(model)
 
-	// Set ID if not set
-	if _, hasID := record["id"]; !hasID {
-		record["id"] = mdb.nextID[tableName]
-		mdb.nextID[tableName]++
+	// Assign the next ID when a single-column primary key is unset
+	if keys := primaryKeyFields(model); len(keys) == 1 {
+		pk := keys[0]
+		pkValue := reflect.ValueOf(model).Elem().FieldByIndex(pk.Index)
+		if pkValue.IsZero() {
+			id := mdb.nextID[tableName]
+			mdb.nextID[tableName]++
 
-		// Set ID back to model if possible
-		if v := reflect.ValueOf(model); v.Kind() == reflect.Ptr {
-			elem := v.Elem()
-			if idField := elem.FieldByName("ID"); idField.IsValid() && idField.CanSet() {
-				idField.SetUint(uint64(record["id"].(int)))
+			if pkValue.CanSet() {
+				assignValue(pkValue, id)
 			}
+			record[pk.Column] = pkValue.Interface()
 		}
 	}
 
@@ -586,6 +1711,28 @@ func (mdb *MockDB) Create(model interface{}) error {
 	return nil
 }
 
+// applyDefaults sets the zero use_db_default fields of model to their
+// default: literal, as the database would. CURRENT_TIMESTAMP is the current
+// time; defaults the mock can't evaluate leave the field zero.
+func (mdb *MockDB) applyDefaults(model interface{}) {
+	elem := reflect.ValueOf(model).Elem()
+	for _, field := range modelFields(elem.Type()) {
+		value := elem.FieldByIndex(field.Index)
+		if !field.usesDBDefault(value) || !value.CanSet() {
+			continue
+		}
+
+		literal, _ := field.defaultValue()
+		if strings.EqualFold(literal, "CURRENT_TIMESTAMP") {
+			assignValue(value, time.Now())
+			continue
+		}
+		if err := assignValue(value, strings.Trim(literal, "'")); err != nil {
+			value.Set(reflect.Zero(value.Type()))
+		}
+	}
+}
+
 // MockFindAll simulates finding all records
 func (mdb *MockDB) FindAll(model interface{}) (interface{}, error) {
 	tableName := mdb.getTableName(model)
@@ -618,33 +1765,244 @@ func (mdb *MockDB) FindAll(model interface{}) (interface{}, error) {
 	return results.Interface(), nil
 }
 
+// Find returns copies of the records of tableName for which predicate
+// returns true, in insertion order. Records map column names to the values
+// Create stored. It is the mock's counterpart to a filtered SELECT, and what
+// QuerySets run on with the mock database.
+func (mdb *MockDB) Find(tableName string, predicate func(map[string]interface{}) bool) []map[string]interface{} {
+	mdb.mutex.RLock()
+	defer mdb.mutex.RUnlock()
+
+	var found []map[string]interface{}
+	for _, record := range mdb.tables[tableName] {
+		if !predicate(record) {
+			continue
+		}
+		copied := make(map[string]interface{}, len(record))
+		for column, value := range record {
+			copied[column] = value
+		}
+		found = append(found, copied)
+	}
+	return found
+}
+
+// find returns the stored records of model's table that match, as a slice
+// of model pointers in insertion order
+func (mdb *MockDB) find(model interface{}, match func(map[string]interface{}) bool) (interface{}, error) {
+	modelType := reflect.TypeOf(model).Elem()
+
+	results := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(modelType)), 0, 0)
+	for _, record := range mdb.Find(mdb.getTableName(model), match) {
+		newModel := reflect.New(modelType)
+		if err := mdb.mapToModel(record, newModel.Interface()); err != nil {
+			return nil, err
+		}
+		results = reflect.Append(results, newModel)
+	}
+	return results.Interface(), nil
+}
+
+// storedValue returns what a mock record's value stands for in SQL,
+// unwrapping driver.Valuer types such as sql.NullString; nil is NULL
+func storedValue(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if unwrapped, err := valuer.Value(); err == nil {
+			return unwrapped
+		}
+	}
+	return value
+}
+
 // MockFindByID simulates finding a record by ID
-func (mdb *MockDB) FindByID(model interface{}, id string) error {
+func (mdb *MockDB) FindByID(model interface{}, id interface{}) error {
 	tableName := mdb.getTableName(model)
 
+	columns, values, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
 	mdb.mutex.RLock()
 	defer mdb.mutex.RUnlock()
 
-	records, exists := mdb.tables[tableName]
-	if !exists {
-		return fmt.Errorf("record not found")
+	for _, record := range mdb.tables[tableName] {
+		if matchesKey(record, columns, values) {
+			return mdb.mapToModel(record, model)
+		}
+	}
+
+	return fmt.Errorf("%w: %s with id %v", ErrNotFound, tableName, id)
+}
+
+// MockExists reports whether a stored record has value in column
+func (mdb *MockDB) Exists(model interface{}, column string, value interface{}) bool {
+	tableName := mdb.getTableName(model)
+
+	mdb.mutex.RLock()
+	defer mdb.mutex.RUnlock()
+
+	for _, record := range mdb.tables[tableName] {
+		if stored, ok := record[column]; ok && fmt.Sprintf("%v", stored) == fmt.Sprintf("%v", value) {
+			return true
+		}
+	}
+	return false
+}
+
+// MockCount returns the number of stored records
+func (mdb *MockDB) Count(model interface{}) int {
+	tableName := mdb.getTableName(model)
+
+	mdb.mutex.RLock()
+	defer mdb.mutex.RUnlock()
+
+	return len(mdb.tables[tableName])
+}
+
+// MockUpdate simulates updating a record by ID
+func (mdb *MockDB) Update(model interface{}, id interface{}) error {
+	tableName := mdb.getTableName(model)
+
+	columns, values, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	for i, record := range mdb.tables[tableName] {
+		if matchesKey(record, columns, values) {
+			updated := mdb.modelToMap(model)
+			for _, column := range columns {
+				updated[column] = record[column]
+			}
+			mdb.tables[tableName][i] = updated
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s with id %v", ErrNotFound, tableName, id)
+}
+
+// MockDelete simulates deleting a record by ID
+func (mdb *MockDB) Delete(model interface{}, id interface{}) error {
+	tableName := mdb.getTableName(model)
+
+	columns, values, err := keyColumns(model, id)
+	if err != nil {
+		return err
+	}
+
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	records := mdb.tables[tableName]
+	for i, record := range records {
+		if matchesKey(record, columns, values) {
+			mdb.tables[tableName] = append(records[:i:i], records[i+1:]...)
+			return nil
+		}
 	}
 
-	// Convert ID to int for comparison
-	var targetID int
-	if _, err := fmt.Sscanf(id, "%d", &targetID); err != nil {
-		return fmt.Errorf("invalid ID format")
+	return fmt.Errorf("%w: %s with id %v", ErrNotFound, tableName, id)
+}
+
+// UpdateWhere sets the columns of data on the records of tableName that
+// match, and returns how many matched
+func (mdb *MockDB) UpdateWhere(tableName string, match func(map[string]interface{}) bool, data map[string]interface{}) int64 {
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	var updated int64
+	for _, record := range mdb.tables[tableName] {
+		if !match(record) {
+			continue
+		}
+		for column, value := range data {
+			record[column] = value
+		}
+		updated++
 	}
+	return updated
+}
 
+// DeleteWhere removes the records of tableName that match, and returns how
+// many it removed. Unlike DB.DeleteWhere it applies no on_delete rules.
+func (mdb *MockDB) DeleteWhere(tableName string, match func(map[string]interface{}) bool) int64 {
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	records := mdb.tables[tableName]
+	kept := records[:0:0]
 	for _, record := range records {
-		if recordID, hasID := record["id"]; hasID && recordID == targetID {
-			return mdb.mapToModel(record, model)
+		if !match(record) {
+			kept = append(kept, record)
+		}
+	}
+	mdb.tables[tableName] = kept
+	return int64(len(records) - len(kept))
+}
+
+// matchesKey reports whether a stored record has the given key values
+func matchesKey(record map[string]interface{}, columns []string, values []interface{}) bool {
+	for i, column := range columns {
+		stored, ok := record[column]
+		if !ok || fmt.Sprintf("%v", stored) != fmt.Sprintf("%v", values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MockUpsert simulates an upsert keyed on the conflict columns
+func (mdb *MockDB) Upsert(model interface{}, conflictColumns []string) (UpsertResult, error) {
+	tableName := mdb.getTableName(model)
+	record := mdb.modelToMap(model)
+
+	keys := []string{"id"}
+	if fields := primaryKeyFields(model); len(fields) > 0 {
+		keys = keys[:0]
+		for _, field := range fields {
+			keys = append(keys, field.Column)
+		}
+	}
+
+	// Look up and insert under one lock, so concurrent upserts of the same
+	// key can't both insert
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	for i, existing := range mdb.tables[tableName] {
+		matches := true
+		for _, column := range conflictColumns {
+			if fmt.Sprintf("%v", existing[column]) != fmt.Sprintf("%v", record[column]) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			// The existing row keeps its primary key
+			for _, key := range keys {
+				if value, ok := existing[key]; ok {
+					record[key] = value
+				}
+			}
+			if createdAt, ok := existing["created_at"]; ok {
+				record["created_at"] = createdAt
+			}
+			mdb.tables[tableName][i] = record
+			return UpsertUpdated, mdb.mapToModel(record, model)
 		}
 	}
 
-	return fmt.Errorf("record not found")
+	return UpsertInserted, mdb.insert(model)
 }
 
+// mapToModel fills a model from a stored record, matching db-tag column names
+// and coercing values the same way rows scanned from SQLite are
 func (mdb *MockDB) mapToModel(data map[string]interface{}, model interface{}) error {
 	v := reflect.ValueOf(model)
 	if v.Kind() != reflect.Ptr {
@@ -652,23 +2010,20 @@ func (mdb *MockDB) mapToModel(data map[string]interface{}, model interface{}) er
 	}
 
 	elem := v.Elem()
-	t := elem.Type()
-
-	for i := 0; i < elem.NumField(); i++ {
-		field := t.Field(i)
-		value := elem.Field(i)
 
+	for _, field := range modelFields(elem.Type()) {
+		value := elem.FieldByIndex(field.Index)
 		if !value.CanSet() {
 			continue
 		}
 
-		fieldName := strings.ToLower(field.Name)
+		dataValue, exists := data[field.Column]
+		if !exists {
+			continue
+		}
 
-		if dataValue, exists := data[fieldName]; exists && dataValue != nil {
-			dataVal := reflect.ValueOf(dataValue)
-			if dataVal.Type().ConvertibleTo(value.Type()) {
-				value.Set(dataVal.Convert(value.Type()))
-			}
+		if err := field.assign(value, dataValue); err != nil {
+			return fmt.Errorf("column %s: %v", field.Column, err)
 		}
 	}
 
@@ -678,7 +2033,9 @@ func (mdb *MockDB) mapToModel(data map[string]interface{}, model interface{}) er
 // Helper methods for MockDB
 func (mdb *MockDB) getTableName(model interface{}) string {
 	if tabler, ok := model.(interface{ TableName() string }); ok {
-		return tabler.TableName()
+		if name := tabler.TableName(); name != "" {
+			return name
+		}
 	}
 
 	// Default to struct name
@@ -697,15 +2054,20 @@ func (mdb *MockDB) modelToMap(model interface{}) map[string]interface{} {
 		v = v.Elem()
 	}
 
-	t := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		field := t.Field(i)
-		value := v.Field(i)
-
-		if value.CanInterface() {
-			fieldName := strings.ToLower(field.Name)
-			result[fieldName] = value.Interface()
+	for _, field := range modelFields(v.Type()) {
+		value := v.FieldByIndex(field.Index)
+		if !value.CanInterface() {
+			continue
+		}
+		// Keep what pointer fields point to, not the caller's pointer
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				result[field.Column] = nil
+				continue
+			}
+			value = value.Elem()
 		}
+		result[field.Column] = value.Interface()
 	}
 
 	return result
@@ -719,7 +2081,7 @@ func (db *DB) Query(query string, args ...interface{}) (interface{}, error) {
 		return []interface{}{}, nil
 	}
 
-	rows, err := db.Conn.Query(query, args...)
+	rows, err := db.QueryContext(context.Background(), query, args...)
 	if err != nil {
 		return nil, err
 	}
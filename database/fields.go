@@ -0,0 +1,539 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldInfo describes a struct field that maps to a database column
+type fieldInfo struct {
+	Field   reflect.StructField
+	Index   []int
+	Column  string
+	Options []string
+}
+
+// has reports whether the field's db tag contains the given option
+func (f fieldInfo) has(option string) bool {
+	for _, opt := range f.Options {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// modelFields returns the column-mapped fields of a struct type, descending
+// into embedded structs (like models.Model) that carry no db tag themselves
+func modelFields(t reflect.Type) []fieldInfo {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		dbTag := field.Tag.Get("db")
+
+		if field.Anonymous && dbTag == "" && field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			for _, embedded := range modelFields(field.Type) {
+				embedded.Index = append([]int{i}, embedded.Index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+
+		if !field.IsExported() || dbTag == "" || dbTag == "-" {
+			continue
+		}
+
+		parts := strings.Split(dbTag, ",")
+		if parts[0] == "" || strings.HasPrefix(parts[0], m2mPrefix) {
+			continue
+		}
+
+		options := make([]string, 0, len(parts)-1)
+		for _, part := range parts[1:] {
+			options = append(options, strings.TrimSpace(part))
+		}
+
+		fields = append(fields, fieldInfo{
+			Field:   field,
+			Index:   []int{i},
+			Column:  parts[0],
+			Options: options,
+		})
+	}
+
+	return fields
+}
+
+// Column describes a model field that maps to a database column
+type Column struct {
+	// Name is the column name from the db tag
+	Name string
+	// Field is the struct field; Index locates it for reflect.Value.FieldByIndex
+	Field reflect.StructField
+	Index []int
+	// Options are the db tag options after the column name
+	Options       []string
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// Columns returns the column-mapped fields of a model in declaration order,
+// including those of embedded structs such as models.Model. It exposes the
+// metadata the ORM itself uses, for building forms and other tooling.
+func Columns(model interface{}) []Column {
+	fields := modelFields(reflect.TypeOf(model))
+	columns := make([]Column, len(fields))
+	for i, field := range fields {
+		columns[i] = Column{
+			Name:          field.Column,
+			Field:         field.Field,
+			Index:         field.Index,
+			Options:       field.Options,
+			PrimaryKey:    field.has("primary_key"),
+			AutoIncrement: field.has("auto_increment"),
+		}
+	}
+	return columns
+}
+
+// SetColumns fills the fields of model, a struct pointer, from a map of
+// column name to value, converting values the way rows read from the
+// database are (numbers between widths, strings to time.Time, JSON text to
+// maps and structs...). A column the model doesn't map is an error.
+func SetColumns(model interface{}, values map[string]interface{}) error {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct, got %T", model)
+	}
+	elem := v.Elem()
+
+	fields := make(map[string]fieldInfo)
+	for _, field := range modelFields(elem.Type()) {
+		fields[field.Column] = field
+	}
+
+	for column, value := range values {
+		field, ok := fields[column]
+		if !ok {
+			return fmt.Errorf("%T has no column %q", model, column)
+		}
+		if err := field.assign(elem.FieldByIndex(field.Index), value); err != nil {
+			return fmt.Errorf("column %s: %v", column, err)
+		}
+	}
+	return nil
+}
+
+// isJSON reports whether the field is stored as JSON text: either it is tagged
+// type:JSON, or it is a map, a non-byte slice or a plain struct that the
+// driver can't store natively
+func (f fieldInfo) isJSON() bool {
+	for _, opt := range f.Options {
+		if strings.EqualFold(opt, "type:JSON") {
+			return true
+		}
+	}
+
+	t := f.Field.Type
+	if t.Implements(valuerType) || reflect.PtrTo(t).Implements(scannerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	case reflect.Struct:
+		return t != timeType
+	}
+	return false
+}
+
+// columnValue returns the value to write for a field, marshaling JSON fields
+func (f fieldInfo) columnValue(value reflect.Value) (interface{}, error) {
+	if f.isJSON() {
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s as JSON: %v", f.Column, err)
+		}
+		return string(data), nil
+	}
+	return value.Interface(), nil
+}
+
+// assign stores a scanned column value into the field, decoding JSON fields
+func (f fieldInfo) assign(dst reflect.Value, src interface{}) error {
+	if f.isJSON() {
+		var data []byte
+		switch v := src.(type) {
+		case nil:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			// Already decoded (e.g. values kept in memory by the mock)
+			return assignValue(dst, src)
+		}
+
+		target := reflect.New(dst.Type())
+		if err := json.Unmarshal(data, target.Interface()); err != nil {
+			return fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		dst.Set(target.Elem())
+		return nil
+	}
+
+	return assignValue(dst, src)
+}
+
+// defaultValue returns the SQL literal of the field's default: option
+func (f fieldInfo) defaultValue() (string, bool) {
+	for _, opt := range f.Options {
+		if strings.HasPrefix(opt, "default:") {
+			return strings.TrimPrefix(opt, "default:"), true
+		}
+	}
+	return "", false
+}
+
+// usesDBDefault reports whether Create leaves the column out so the database
+// fills in its default: the field is tagged use_db_default and default:, and
+// value is the zero value
+func (f fieldInfo) usesDBDefault(value reflect.Value) bool {
+	if !f.has("use_db_default") || !value.IsZero() {
+		return false
+	}
+	_, ok := f.defaultValue()
+	return ok
+}
+
+// primaryKeyField returns the field tagged primary_key, if the model has one
+func primaryKeyField(model interface{}) (fieldInfo, bool) {
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		if field.has("primary_key") {
+			return field, true
+		}
+	}
+	return fieldInfo{}, false
+}
+
+// primaryKeyFields returns every field tagged primary_key, in declaration
+// order; more than one means the model has a composite key
+func primaryKeyFields(model interface{}) []fieldInfo {
+	var keys []fieldInfo
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		if field.has("primary_key") {
+			keys = append(keys, field)
+		}
+	}
+	return keys
+}
+
+// keyColumns resolves id to the columns and values identifying one row of
+// model's table. A single value matches the primary key column, or id when
+// the model declares none; a composite key takes a map[string]interface{}
+// with a value for each of its columns.
+func keyColumns(model interface{}, id interface{}) ([]string, []interface{}, error) {
+	keys := primaryKeyFields(model)
+
+	values, isMap := id.(map[string]interface{})
+	if !isMap {
+		if len(keys) > 1 {
+			return nil, nil, fmt.Errorf("%T has a composite primary key; pass a map of its key columns", model)
+		}
+		column := "id"
+		if len(keys) == 1 {
+			column = keys[0].Column
+		}
+		return []string{column}, []interface{}{id}, nil
+	}
+
+	if len(keys) == 0 {
+		keys = []fieldInfo{{Column: "id"}}
+	}
+	if len(values) != len(keys) {
+		return nil, nil, fmt.Errorf("%T key needs %d columns, got %d", model, len(keys), len(values))
+	}
+	columns := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		value, ok := values[key.Column]
+		if !ok {
+			return nil, nil, fmt.Errorf("%T key is missing column %q", model, key.Column)
+		}
+		columns[i] = key.Column
+		args[i] = value
+	}
+	return columns, args, nil
+}
+
+// modelKey returns model's primary key in the form keyColumns accepts: the
+// key field's value, a map of column to value for a composite key, or nil
+// when the model declares none
+func modelKey(model interface{}) interface{} {
+	keys := primaryKeyFields(model)
+	value := reflect.Indirect(reflect.ValueOf(model))
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return value.FieldByIndex(keys[0].Index).Interface()
+	}
+
+	id := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		id[key.Column] = value.FieldByIndex(key.Index).Interface()
+	}
+	return id
+}
+
+// keyWhere returns the WHERE condition matching the given key columns
+func keyWhere(columns []string) string {
+	conditions := make([]string, len(columns))
+	for i, column := range columns {
+		conditions[i] = column + " = ?"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// nullTypes maps the sql.Null types to the type of the value they hold
+var nullTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+	reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+	reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+	reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+	reflect.TypeOf(sql.NullByte{}):    reflect.TypeOf(byte(0)),
+	reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+	reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+	reflect.TypeOf(sql.NullTime{}):    timeType,
+}
+
+// valueType returns the type of the values the field's column stores: the
+// element type of a pointer, or the value type of an sql.Null type
+func (f fieldInfo) valueType() reflect.Type {
+	t := f.Field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if inner, ok := nullTypes[t]; ok {
+		return inner
+	}
+	return t
+}
+
+// nullable reports whether the field can hold NULL: it is a pointer or an
+// sql.Null type
+func (f fieldInfo) nullable() bool {
+	_, isNull := nullTypes[f.Field.Type]
+	return isNull || f.Field.Type.Kind() == reflect.Ptr
+}
+
+// timeLayouts lists the formats SQLite and the mock backend store datetimes in
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime parses a datetime string in any of the supported layouts
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	// Go's time.Time.String() appends a monotonic clock reading
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as time", s)
+}
+
+// assignValue stores src into dst, converting between the representations
+// a driver may return (strings for datetimes, int64 for any integer, etc.)
+func assignValue(dst reflect.Value, src interface{}) error {
+	// A value of the field's own type, as the mock keeps in memory
+	if src != nil && reflect.TypeOf(src) == dst.Type() {
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+
+	// Types that know how to scan themselves (sql.NullString, custom types)
+	if dst.CanAddr() {
+		if scanner, ok := dst.Addr().Interface().(sql.Scanner); ok {
+			return scanner.Scan(src)
+		}
+	}
+
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	srcValue := reflect.ValueOf(src)
+	if srcValue.Type().AssignableTo(dst.Type()) {
+		dst.Set(srcValue)
+		return nil
+	}
+
+	// Pointer fields get a new value; NULL left them nil above
+	if dst.Kind() == reflect.Ptr {
+		elem := reflect.New(dst.Type().Elem())
+		if err := assignValue(elem.Elem(), src); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	if b, ok := src.([]byte); ok && dst.Kind() != reflect.Slice {
+		src = string(b)
+		srcValue = reflect.ValueOf(src)
+	}
+
+	if dst.Type() == timeType {
+		switch v := src.(type) {
+		case string:
+			t, err := parseTime(v)
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		case int64:
+			dst.Set(reflect.ValueOf(time.Unix(v, 0)))
+			return nil
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch srcValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetInt(srcValue.Int())
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			dst.SetInt(int64(srcValue.Uint()))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetInt(int64(srcValue.Float()))
+			return nil
+		case reflect.Bool:
+			if srcValue.Bool() {
+				dst.SetInt(1)
+			} else {
+				dst.SetInt(0)
+			}
+			return nil
+		case reflect.String:
+			i, err := strconv.ParseInt(srcValue.String(), 10, 64)
+			if err != nil {
+				return err
+			}
+			dst.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch srcValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetUint(uint64(srcValue.Int()))
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			dst.SetUint(srcValue.Uint())
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetUint(uint64(srcValue.Float()))
+			return nil
+		case reflect.String:
+			u, err := strconv.ParseUint(srcValue.String(), 10, 64)
+			if err != nil {
+				return err
+			}
+			dst.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch srcValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetFloat(float64(srcValue.Int()))
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			dst.SetFloat(float64(srcValue.Uint()))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(srcValue.Float())
+			return nil
+		case reflect.String:
+			f, err := strconv.ParseFloat(srcValue.String(), 64)
+			if err != nil {
+				return err
+			}
+			dst.SetFloat(f)
+			return nil
+		}
+	case reflect.Bool:
+		switch srcValue.Kind() {
+		case reflect.Bool:
+			dst.SetBool(srcValue.Bool())
+			return nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetBool(srcValue.Int() != 0)
+			return nil
+		case reflect.String:
+			b, err := strconv.ParseBool(srcValue.String())
+			if err != nil {
+				return err
+			}
+			dst.SetBool(b)
+			return nil
+		}
+	case reflect.String:
+		switch srcValue.Kind() {
+		case reflect.String:
+			dst.SetString(srcValue.String())
+			return nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.Bool:
+			dst.SetString(fmt.Sprintf("%v", src))
+			return nil
+		}
+	}
+
+	if srcValue.Type().ConvertibleTo(dst.Type()) {
+		dst.Set(srcValue.Convert(dst.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot assign %T to %s", src, dst.Type())
+}
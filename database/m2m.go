@@ -0,0 +1,462 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// manyToMany is a relation declared by a slice field tagged m2m:join_table,
+// as in
+//
+//	Tags []Tag `db:"m2m:post_tags"`
+//
+// The join table has one column referencing each side, named after the model
+// and its key (post_id, tag_id), and their pair as its primary key.
+type manyToMany struct {
+	name      string // struct field name
+	index     []int
+	sliceType reflect.Type
+	joinTable string
+
+	sourceTable  string
+	sourceKey    fieldInfo
+	sourceColumn string // join column referencing the source row
+
+	target       reflect.Type
+	targetTable  string
+	targetKey    fieldInfo
+	targetColumn string // join column referencing the target row
+}
+
+// m2mPrefix marks a db tag as a many-to-many relation rather than a column
+const m2mPrefix = "m2m:"
+
+// manyToManyFields returns the many-to-many relations a model declares
+func (db *DB) manyToManyFields(model interface{}) ([]manyToMany, error) {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var relations []manyToMany
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		joinTable, ok := strings.CutPrefix(field.Tag.Get("db"), m2mPrefix)
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		rel, err := db.manyToManyField(model, field, []int{i}, joinTable)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+	}
+	return relations, nil
+}
+
+// relation returns the many-to-many relation declared by the named field
+func (db *DB) relation(model interface{}, name string) (manyToMany, error) {
+	relations, err := db.manyToManyFields(model)
+	if err != nil {
+		return manyToMany{}, err
+	}
+	for _, rel := range relations {
+		if rel.name == name {
+			return rel, nil
+		}
+	}
+	return manyToMany{}, fmt.Errorf("%T has no many-to-many field %q", model, name)
+}
+
+// manyToManyField resolves the tables and key columns of one relation
+func (db *DB) manyToManyField(model interface{}, field reflect.StructField, index []int, joinTable string) (manyToMany, error) {
+	if joinTable == "" {
+		return manyToMany{}, fmt.Errorf("field %s: m2m needs a join table name", field.Name)
+	}
+
+	target := field.Type
+	if target.Kind() == reflect.Slice {
+		target = target.Elem()
+		if target.Kind() == reflect.Ptr {
+			target = target.Elem()
+		}
+	}
+	if field.Type.Kind() != reflect.Slice || target.Kind() != reflect.Struct {
+		return manyToMany{}, fmt.Errorf("field %s: m2m needs a slice of models, got %s", field.Name, field.Type)
+	}
+
+	source := reflect.TypeOf(model)
+	if source.Kind() == reflect.Ptr {
+		source = source.Elem()
+	}
+	targetModel := reflect.New(target).Interface()
+
+	sourceKey, err := singleKey(model)
+	if err != nil {
+		return manyToMany{}, err
+	}
+	targetKey, err := singleKey(targetModel)
+	if err != nil {
+		return manyToMany{}, err
+	}
+
+	sourceColumn := strings.ToLower(source.Name()) + "_" + sourceKey.Column
+	targetColumn := strings.ToLower(target.Name()) + "_" + targetKey.Column
+	if sourceColumn == targetColumn {
+		// A model related to itself, such as User.Friends
+		sourceColumn, targetColumn = "from_"+sourceColumn, "to_"+targetColumn
+	}
+
+	return manyToMany{
+		name:         field.Name,
+		index:        index,
+		sliceType:    field.Type,
+		joinTable:    joinTable,
+		sourceTable:  db.getTableName(model),
+		sourceKey:    sourceKey,
+		sourceColumn: sourceColumn,
+		target:       target,
+		targetTable:  db.getTableName(targetModel),
+		targetKey:    targetKey,
+		targetColumn: targetColumn,
+	}, nil
+}
+
+// singleKey returns the primary key field of a model that a join table can
+// reference: its single primary_key field, or its id column
+func singleKey(model interface{}) (fieldInfo, error) {
+	keys := primaryKeyFields(model)
+	if len(keys) > 1 {
+		return fieldInfo{}, fmt.Errorf("%T has a composite primary key, which many-to-many relations don't support", model)
+	}
+	if len(keys) == 1 {
+		return keys[0], nil
+	}
+	for _, field := range modelFields(reflect.TypeOf(model)) {
+		if field.Column == "id" {
+			return field, nil
+		}
+	}
+	return fieldInfo{}, fmt.Errorf("%T has no primary key", model)
+}
+
+// joinTableSQL returns the CREATE TABLE statement for the relation's join table
+func (rel manyToMany) joinTableSQL() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s %s NOT NULL REFERENCES %s(%s),\n  %s %s NOT NULL REFERENCES %s(%s),\n  PRIMARY KEY (%s, %s)\n)",
+		rel.joinTable,
+		rel.sourceColumn, keyColumnType(rel.sourceKey), rel.sourceTable, rel.sourceKey.Column,
+		rel.targetColumn, keyColumnType(rel.targetKey), rel.targetTable, rel.targetKey.Column,
+		rel.sourceColumn, rel.targetColumn)
+}
+
+// foreignKeys returns the join table's references, which cascade so that
+// deleting either side removes its join rows
+func (rel manyToMany) foreignKeys() []foreignKey {
+	return []foreignKey{
+		{table: rel.joinTable, column: rel.sourceColumn, refTable: rel.sourceTable, refColumn: rel.sourceKey.Column, onDelete: OnDeleteCascade},
+		{table: rel.joinTable, column: rel.targetColumn, refTable: rel.targetTable, refColumn: rel.targetKey.Column, onDelete: OnDeleteCascade},
+	}
+}
+
+// keyColumnType returns the type of a join column referencing key
+func keyColumnType(key fieldInfo) string {
+	switch key.Field.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	}
+	return "TEXT"
+}
+
+// RelatedManager adds and removes the rows of a many-to-many relation for
+// one record; get one with DB.Related
+type RelatedManager struct {
+	db       *DB
+	ctx      context.Context
+	model    interface{}
+	rel      manyToMany
+	sourceID interface{}
+	err      error
+}
+
+// Related returns the manager of model's many-to-many field, named by its
+// struct field. model must be saved, so that its primary key is set:
+//
+//	err := db.Related(post, "Tags").Add(&golang, &web)
+//
+// Related values may be model pointers or bare primary key values. Changes
+// don't touch model's field; call Load to refresh it.
+func (db *DB) Related(model interface{}, field string) *RelatedManager {
+	m := &RelatedManager{db: db, ctx: context.Background(), model: model}
+	if db.mock != nil {
+		m.err = fmt.Errorf("many-to-many relations are not supported by the mock database")
+		return m
+	}
+
+	rel, err := db.relation(model, field)
+	if err != nil {
+		m.err = err
+		return m
+	}
+	m.rel = rel
+
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		m.err = fmt.Errorf("model must be a pointer to a struct, got %T", model)
+		return m
+	}
+	key := value.Elem().FieldByIndex(rel.sourceKey.Index)
+	if key.IsZero() {
+		m.err = fmt.Errorf("%s has no primary key; save it before relating it", rel.sourceTable)
+		return m
+	}
+	m.sourceID = key.Interface()
+	return m
+}
+
+// WithContext returns a copy of m that runs its queries with ctx
+func (m *RelatedManager) WithContext(ctx context.Context) *RelatedManager {
+	copied := *m
+	copied.ctx = ctx
+	return &copied
+}
+
+// Add relates the given records; ones already related are left as they are
+func (m *RelatedManager) Add(related ...interface{}) error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.db.TransactionContext(m.ctx, func(tx *DB) error {
+		return m.add(tx, related)
+	})
+}
+
+// Remove unrelates the given records
+func (m *RelatedManager) Remove(related ...interface{}) error {
+	if m.err != nil {
+		return m.err
+	}
+	ids, err := m.relatedKeys(related)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	where := fmt.Sprintf("%s = ? AND %s IN (%s)", m.rel.sourceColumn, m.rel.targetColumn, placeholderList(len(ids)))
+	_, err = m.db.deleteRows(m.ctx, m.db.writer(), m.rel.joinTable, where, append([]interface{}{m.sourceID}, ids...))
+	return err
+}
+
+// Set replaces the related records with exactly the given ones
+func (m *RelatedManager) Set(related ...interface{}) error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.db.TransactionContext(m.ctx, func(tx *DB) error {
+		if err := m.clear(tx); err != nil {
+			return err
+		}
+		return m.add(tx, related)
+	})
+}
+
+// Clear unrelates every record
+func (m *RelatedManager) Clear() error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.clear(m.db)
+}
+
+// Load fills the relation's field of the model with its related records
+func (m *RelatedManager) Load() error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.db.PrefetchContext(m.ctx, m.model, m.rel.name)
+}
+
+// add inserts a join row for each related record on db
+func (m *RelatedManager) add(db *DB, related []interface{}) error {
+	ids, err := m.relatedKeys(related)
+	if err != nil {
+		return err
+	}
+
+	insertSQL := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		m.rel.joinTable, m.rel.sourceColumn, m.rel.targetColumn)
+	for _, id := range ids {
+		if _, err := db.ExecContext(m.ctx, insertSQL, m.sourceID, id); err != nil {
+			return fmt.Errorf("failed to add %s row: %v", m.rel.joinTable, err)
+		}
+	}
+	return nil
+}
+
+// clear deletes every join row of the record on db
+func (m *RelatedManager) clear(db *DB) error {
+	_, err := db.deleteRows(m.ctx, db.writer(), m.rel.joinTable, m.rel.sourceColumn+" = ?", []interface{}{m.sourceID})
+	return err
+}
+
+// relatedKeys returns the primary key of each related value, which is either
+// a target model (or a pointer to one) or a bare key
+func (m *RelatedManager) relatedKeys(related []interface{}) ([]interface{}, error) {
+	ids := make([]interface{}, len(related))
+	for i, item := range related {
+		value := reflect.ValueOf(item)
+		if value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Type() != m.rel.target {
+			ids[i] = item
+			continue
+		}
+
+		key := value.FieldByIndex(m.rel.targetKey.Index)
+		if key.IsZero() {
+			return nil, fmt.Errorf("%s has no primary key; save it before relating it", m.rel.targetTable)
+		}
+		ids[i] = key.Interface()
+	}
+	return ids, nil
+}
+
+// Prefetch loads the named many-to-many fields of records, a model pointer
+// or a slice of them as returned by FindAll, with one query per field for the
+// join rows and one for the related records, instead of one per record:
+//
+//	posts, _ := db.FindAll(&Post{})
+//	err := db.Prefetch(posts, "Tags")
+//
+// Related records are ordered by primary key; records without any get an
+// empty slice.
+func (db *DB) Prefetch(records interface{}, fields ...string) error {
+	return db.PrefetchContext(context.Background(), records, fields...)
+}
+
+// PrefetchContext is Prefetch, aborting if ctx is cancelled
+func (db *DB) PrefetchContext(ctx context.Context, records interface{}, fields ...string) error {
+	if db.mock != nil {
+		return fmt.Errorf("many-to-many relations are not supported by the mock database")
+	}
+
+	// Collect the records as addressable structs
+	var items []reflect.Value
+	value := reflect.ValueOf(records)
+	switch {
+	case value.Kind() == reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			item := reflect.Indirect(value.Index(i))
+			if item.Kind() != reflect.Struct || !item.CanSet() {
+				return fmt.Errorf("prefetch needs a slice of model pointers, got %T", records)
+			}
+			items = append(items, item)
+		}
+	case value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct:
+		items = append(items, value.Elem())
+	default:
+		return fmt.Errorf("prefetch needs a model pointer or a slice of them, got %T", records)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	model := items[0].Addr().Interface()
+	for _, name := range fields {
+		rel, err := db.relation(model, name)
+		if err != nil {
+			return err
+		}
+		if err := db.prefetch(ctx, rel, items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefetch loads one relation into items
+func (db *DB) prefetch(ctx context.Context, rel manyToMany, items []reflect.Value) error {
+	sourceIDs := make([]interface{}, len(items))
+	for i, item := range items {
+		sourceIDs[i] = item.FieldByIndex(rel.sourceKey.Index).Interface()
+	}
+
+	// Which targets each source is related to
+	joinSQL := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IN (%s)",
+		rel.sourceColumn, rel.targetColumn, rel.joinTable, rel.sourceColumn, placeholderList(len(sourceIDs)))
+	rows, err := db.QueryContext(ctx, joinSQL, sourceIDs...)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %v", rel.joinTable, err)
+	}
+
+	related := make(map[string]map[string]bool)
+	var targetIDs []interface{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var sourceID, targetID interface{}
+		if err := rows.Scan(&sourceID, &targetID); err != nil {
+			rows.Close()
+			return err
+		}
+		source, target := keyString(sourceID), keyString(targetID)
+		if related[source] == nil {
+			related[source] = make(map[string]bool)
+		}
+		related[source][target] = true
+		if !seen[target] {
+			seen[target] = true
+			targetIDs = append(targetIDs, targetID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The related records themselves
+	var targets reflect.Value
+	if len(targetIDs) > 0 {
+		targetSQL := fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s) ORDER BY %s",
+			rel.targetTable, rel.targetKey.Column, placeholderList(len(targetIDs)), rel.targetKey.Column)
+		rows, err := db.QueryContext(ctx, targetSQL, targetIDs...)
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %v", rel.targetTable, err)
+		}
+		results, err := db.scanRows(rows, reflect.New(rel.target).Interface())
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		targets = reflect.ValueOf(results)
+	}
+
+	pointers := rel.sliceType.Elem().Kind() == reflect.Ptr
+	for i, item := range items {
+		collection := reflect.MakeSlice(rel.sliceType, 0, 0)
+		for j := 0; targets.IsValid() && j < targets.Len(); j++ {
+			target := targets.Index(j)
+			if !related[keyString(sourceIDs[i])][keyString(target.Elem().FieldByIndex(rel.targetKey.Index).Interface())] {
+				continue
+			}
+			if pointers {
+				collection = reflect.Append(collection, target)
+			} else {
+				collection = reflect.Append(collection, target.Elem())
+			}
+		}
+		item.FieldByIndex(rel.index).Set(collection)
+	}
+	return nil
+}
+
+// keyString normalizes a key value so that keys read back from the database
+// ([]byte, int64) match the model's (string, uint)
+func keyString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
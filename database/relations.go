@@ -0,0 +1,256 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrRestricted is returned (wrapped) when a delete is refused because rows
+// declared with on_delete:restrict still reference the rows being deleted
+var ErrRestricted = errors.New("delete restricted by related records")
+
+// on_delete actions
+const (
+	OnDeleteCascade  = "cascade"
+	OnDeleteSetNull  = "set_null"
+	OnDeleteRestrict = "restrict"
+)
+
+// foreignKey is a column declared with fk:table[.column] that references
+// another table's rows
+type foreignKey struct {
+	table     string // referencing (child) table
+	column    string // referencing column
+	refTable  string
+	refColumn string
+	onDelete  string
+}
+
+// execQueryer is satisfied by *sql.DB and *sql.Tx
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// option returns the value of a key:value option from the field's db tag
+func (f fieldInfo) option(key string) (string, bool) {
+	for _, opt := range f.Options {
+		if strings.HasPrefix(opt, key+":") {
+			return strings.TrimPrefix(opt, key+":"), true
+		}
+	}
+	return "", false
+}
+
+// foreignKey returns the reference declared by the field's fk option, as in
+//
+//	AuthorID uint `db:"author_id,fk:users,on_delete:cascade"`
+//
+// The referenced column defaults to id; write fk:users.uuid for another one.
+func (f fieldInfo) foreignKey(table string) (foreignKey, bool, error) {
+	ref, ok := f.option("fk")
+	if !ok {
+		return foreignKey{}, false, nil
+	}
+
+	fk := foreignKey{table: table, column: f.Column, refTable: ref, refColumn: "id"}
+	if dot := strings.Index(ref, "."); dot >= 0 {
+		fk.refTable, fk.refColumn = ref[:dot], ref[dot+1:]
+	}
+	if fk.refTable == "" || fk.refColumn == "" {
+		return foreignKey{}, false, fmt.Errorf("invalid fk option %q on column %s", ref, f.Column)
+	}
+
+	if onDelete, ok := f.option("on_delete"); ok {
+		switch onDelete {
+		case OnDeleteCascade, OnDeleteSetNull, OnDeleteRestrict:
+			fk.onDelete = onDelete
+		default:
+			return foreignKey{}, false, fmt.Errorf("invalid on_delete %q on column %s (want cascade, set_null or restrict)", onDelete, f.Column)
+		}
+	}
+
+	return fk, true, nil
+}
+
+// registerForeignKeys records the foreign keys a model declares, so deletes
+// from the tables they reference can apply their on_delete rules
+func (db *DB) registerForeignKeys(table string, fields []fieldInfo) error {
+	var keys []foreignKey
+	for _, field := range fields {
+		fk, ok, err := field.foreignKey(table)
+		if err != nil {
+			return err
+		}
+		if ok {
+			keys = append(keys, fk)
+		}
+	}
+
+	db.setRelations(table, keys)
+	return nil
+}
+
+// setRelations replaces the foreign keys registered for table with keys
+func (db *DB) setRelations(table string, keys []foreignKey) {
+	db = db.base()
+	db.relationsMu.Lock()
+	defer db.relationsMu.Unlock()
+
+	if db.relations == nil {
+		db.relations = make(map[string][]foreignKey)
+	}
+
+	// Replace what an earlier migration of the same table registered
+	for refTable, existing := range db.relations {
+		kept := existing[:0]
+		for _, fk := range existing {
+			if fk.table != table {
+				kept = append(kept, fk)
+			}
+		}
+		db.relations[refTable] = kept
+	}
+	for _, fk := range keys {
+		db.relations[fk.refTable] = append(db.relations[fk.refTable], fk)
+	}
+}
+
+// referencing returns the foreign keys with an on_delete rule that point at table
+func (db *DB) referencing(table string) []foreignKey {
+	db = db.base()
+	db.relationsMu.RLock()
+	defer db.relationsMu.RUnlock()
+
+	var keys []foreignKey
+	for _, fk := range db.relations[table] {
+		if fk.onDelete != "" {
+			keys = append(keys, fk)
+		}
+	}
+	return keys
+}
+
+// DeleteWhere deletes the rows of model's table matching where, a SQL
+// condition with ? placeholders ("" matches every row). It returns the
+// number of rows deleted.
+func (db *DB) DeleteWhere(model interface{}, where string, args ...interface{}) (int64, error) {
+	return db.DeleteWhereContext(context.Background(), model, where, args...)
+}
+
+// DeleteWhereContext is DeleteWhere, aborting if ctx is cancelled.
+//
+// Tables referencing this one through a migrated fk column have their
+// on_delete rule applied first: cascade deletes the referencing rows (and
+// theirs, recursively), set_null clears the column and restrict refuses the
+// delete with an error wrapping ErrRestricted. All of it runs in one
+// transaction (a savepoint inside Transaction), so a refused or failed
+// delete leaves every table untouched.
+func (db *DB) DeleteWhereContext(ctx context.Context, model interface{}, where string, args ...interface{}) (int64, error) {
+	if db.mock != nil {
+		return 0, fmt.Errorf("DeleteWhere is not supported by the mock database")
+	}
+
+	tableName := db.getTableName(model)
+
+	// Without on_delete rules there is nothing to keep consistent
+	if len(db.referencing(tableName)) == 0 {
+		return db.deleteRows(ctx, db.writer(), tableName, where, args)
+	}
+
+	var deleted int64
+	err := db.TransactionContext(ctx, func(tx *DB) error {
+		if err := tx.applyOnDelete(ctx, tx.tx, tableName, where, args, 0); err != nil {
+			return err
+		}
+
+		var err error
+		deleted, err = tx.deleteRows(ctx, tx.tx, tableName, where, args)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// maxCascadeDepth bounds how many levels of related tables a delete follows
+const maxCascadeDepth = 32
+
+// applyOnDelete applies the on_delete rules of every table referencing the
+// rows of table matching where
+func (db *DB) applyOnDelete(ctx context.Context, q execQueryer, table, where string, args []interface{}, depth int) error {
+	if depth >= maxCascadeDepth {
+		return fmt.Errorf("on_delete cascade from %s exceeds %d levels", table, maxCascadeDepth)
+	}
+
+	for _, fk := range db.referencing(table) {
+		// Rows of fk.table pointing at the rows being deleted
+		parents := fmt.Sprintf("SELECT %s FROM %s", fk.refColumn, table)
+		if where != "" {
+			parents += " WHERE " + where
+		}
+		childWhere := fmt.Sprintf("%s IN (%s)", fk.column, parents)
+
+		var count int
+		countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", fk.table, childWhere)
+		if err := db.queryRowOn(ctx, q, countSQL, args...).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count %s rows referencing %s: %v", fk.table, table, err)
+		}
+		if count == 0 {
+			continue
+		}
+
+		switch fk.onDelete {
+		case OnDeleteRestrict:
+			return fmt.Errorf("%w: %d %s rows reference %s through %s", ErrRestricted, count, fk.table, table, fk.column)
+		case OnDeleteSetNull:
+			updateSQL := fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s", fk.table, fk.column, childWhere)
+			if _, err := db.execOn(ctx, q, updateSQL, args...); err != nil {
+				return fmt.Errorf("failed to clear %s.%s: %v", fk.table, fk.column, err)
+			}
+		case OnDeleteCascade:
+			if err := db.applyOnDelete(ctx, q, fk.table, childWhere, args, depth+1); err != nil {
+				return err
+			}
+			if _, err := db.deleteRows(ctx, q, fk.table, childWhere, args); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteRows runs DELETE FROM table WHERE where and returns the rows affected
+func (db *DB) deleteRows(ctx context.Context, q execQueryer, table, where string, args []interface{}) (int64, error) {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s", table)
+	if where != "" {
+		deleteSQL += " WHERE " + where
+	}
+
+	result, err := db.execOn(ctx, q, deleteSQL, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete from %s: %v", table, err)
+	}
+	return result.RowsAffected()
+}
+
+// execOn runs a statement on q (the connection or a transaction), reporting it to the logger
+func (db *DB) execOn(ctx context.Context, q execQueryer, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := q.ExecContext(ctx, query, args...)
+	db.logQuery(query, args, start, err)
+	return result, err
+}
+
+// queryRowOn runs a single-row query on q, reporting it to the logger
+func (db *DB) queryRowOn(ctx context.Context, q execQueryer, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := q.QueryRowContext(ctx, query, args...)
+	db.logQuery(query, args, start, row.Err())
+	return row
+}
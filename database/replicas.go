@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// primaryKey is the context key set by UsePrimary
+type primaryKey struct{}
+
+// UsePrimary returns a context whose queries read from the primary even when
+// replicas are configured, e.g. to read a row back right after writing it
+// before replication has caught up
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usingPrimary reports whether ctx came from UsePrimary
+func usingPrimary(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+// AddReplica connects to a read replica of the database. Once one is added,
+// SELECTs run by FindAll, FindByID, Count, Exists and QuerySets go to the
+// replicas in turn, while writes, transactions and migrations stay on the
+// primary. Add replicas before the DB is used concurrently.
+func (db *DB) AddReplica(databaseURL string, opts ...Option) error {
+	if db.mock != nil {
+		return fmt.Errorf("replicas are not supported by the mock database")
+	}
+
+	replica, err := Connect(databaseURL, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to replica: %v", err)
+	}
+
+	db.replicas = append(db.replicas, replica.Conn)
+	return nil
+}
+
+// Replicas returns the number of read replicas
+func (db *DB) Replicas() int {
+	return len(db.replicas)
+}
+
+// reader returns the connection a read should use: the next replica in
+// round-robin order, or the primary (or transaction) when there are none or
+// ctx asks for it
+func (db *DB) reader(ctx context.Context) queryer {
+	if len(db.replicas) == 0 || usingPrimary(ctx) {
+		return db.writer()
+	}
+	next := atomic.AddUint32(&db.nextReplica, 1)
+	return db.replicas[int(next-1)%len(db.replicas)]
+}
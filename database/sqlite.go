@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriverName is the SQLite driver Connect opens: go-sqlite3 with the
+// extra SQL functions the ORM relies on registered on every connection
+const sqliteDriverName = "sqlite3_gojango"
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// SQLite parses "x REGEXP y" but ships no regexp() to run it
+			return conn.RegisterFunc("regexp", sqliteRegexp, true)
+		},
+	})
+}
+
+// sqliteRegexpCacheSize bounds sqliteRegexps: patterns can come from user
+// input, so the cache is emptied once it holds this many
+const sqliteRegexpCacheSize = 256
+
+// sqliteRegexps caches compiled patterns, since regexp() runs once per row
+var sqliteRegexps = struct {
+	sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// sqliteRegexp implements "value REGEXP pattern" with Go's regexp syntax
+func sqliteRegexp(pattern string, value interface{}) (bool, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	sqliteRegexps.RLock()
+	re, ok := sqliteRegexps.patterns[pattern]
+	sqliteRegexps.RUnlock()
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false, err
+		}
+
+		sqliteRegexps.Lock()
+		if len(sqliteRegexps.patterns) >= sqliteRegexpCacheSize {
+			sqliteRegexps.patterns = make(map[string]*regexp.Regexp)
+		}
+		sqliteRegexps.patterns[pattern] = re
+		sqliteRegexps.Unlock()
+	}
+	return re.MatchString(s), nil
+}
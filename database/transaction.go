@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	execQueryer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Transaction runs fn in a database transaction, committing when it returns
+// nil and rolling back when it returns an error or panics. Everything fn
+// does through tx (Create, Update, DeleteWhere, QuerySets built on tx, ...)
+// is part of the transaction:
+//
+//	err := db.Transaction(func(tx *database.DB) error {
+//		if err := tx.Create(order); err != nil {
+//			return err
+//		}
+//		return tx.Update(stock, stockID)
+//	})
+//
+// Calling tx.Transaction nests: the inner block runs under a SAVEPOINT, so
+// its error rolls back only its own work (ROLLBACK TO SAVEPOINT) and the
+// outer transaction can carry on and commit; on success the savepoint is
+// released. SQLite, PostgreSQL and MySQL (InnoDB) support savepoints. Note
+// that on PostgreSQL any failed statement aborts the whole transaction
+// unless it ran inside a savepoint, so wrap statements expected to fail in
+// a nested Transaction.
+//
+// tx must only be used by fn's goroutine, and not after fn returns. The mock
+// database calls fn with the DB itself and cannot roll back.
+func (db *DB) Transaction(fn func(tx *DB) error) error {
+	return db.TransactionContext(context.Background(), fn)
+}
+
+// TransactionContext is Transaction, aborting if ctx is cancelled
+func (db *DB) TransactionContext(ctx context.Context, fn func(tx *DB) error) (err error) {
+	if db.mock != nil {
+		return fn(db)
+	}
+	if db.tx != nil {
+		return db.savepoint(ctx, fn)
+	}
+
+	sqlTx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	tx := &DB{Conn: db.Conn, driver: db.driver, tx: sqlTx, root: db}
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// savepoint runs fn as a nested transaction inside db's transaction
+func (db *DB) savepoint(ctx context.Context, fn func(tx *DB) error) error {
+	name := fmt.Sprintf("gojango_sp_%d", db.depth+1)
+	if _, err := db.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %v", err)
+	}
+	inner := &DB{Conn: db.Conn, driver: db.driver, tx: db.tx, root: db.root, depth: db.depth + 1}
+
+	// Undo the inner block even if ctx was cancelled, so the outer
+	// transaction stays usable
+	rollback := func() error {
+		cleanup := context.WithoutCancel(ctx)
+		if _, err := db.ExecContext(cleanup, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(cleanup, "RELEASE SAVEPOINT "+name)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(inner); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return fmt.Errorf("%v (rolling back to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %v", err)
+	}
+	return nil
+}
+
+// InTransaction reports whether db is the tx of a Transaction callback
+func (db *DB) InTransaction() bool {
+	return db.tx != nil
+}
+
+// base returns the DB a transaction was started on, which holds the logger
+// and the registered relations, or db itself outside transactions
+func (db *DB) base() *DB {
+	if db.root != nil {
+		return db.root
+	}
+	return db
+}
+
+// writer returns where statements run: the transaction, if any, or the
+// primary connection
+func (db *DB) writer() queryer {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.Conn
+}
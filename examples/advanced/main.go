@@ -80,14 +80,14 @@ func main() {
 		
 		// Activate multiple users
 		qs := app.NewQuerySet(&User{})
-		err := qs.Filter("id__in", request.UserIDs).Update(map[string]interface{}{
+		activated, err := qs.Filter("id__in", request.UserIDs).Update(map[string]interface{}{
 			"active": true,
 		})
 		if err != nil {
 			return c.ErrorJSON(500, "Database error", err)
 		}
 		
-		return c.JSON(map[string]string{"message": "Users activated"})
+		return c.JSON(map[string]interface{}{"message": "Users activated", "activated": activated})
 	})
 	
 	log.Println("🚀 Advanced QuerySet demo running on :8000")
@@ -103,18 +103,9 @@ func main() {
 func demonstrateQuerySet(app *gojango.App) {
 	log.Println("🔍 Demonstrating Django-like QuerySet operations...")
 	
-	// Create some example users
-	users := []*User{
-		{Name: "John Doe", Email: "john@example.com", Age: 25, Active: true},
-		{Name: "Jane Smith", Email: "jane@example.com", Age: 30, Active: true},
-		{Name: "Bob Wilson", Email: "bob@example.com", Age: 17, Active: false},
-		{Name: "Alice Brown", Email: "alice@example.com", Age: 35, Active: true},
-	}
-	
-	for _, user := range users {
-		if err := app.GetDB().Create(user); err != nil {
-			log.Printf("Error creating user: %v", err)
-		}
+	// Load some example users (run from examples/advanced)
+	if err := app.LoadFixtures("fixtures.yaml"); err != nil {
+		log.Printf("Error loading fixtures: %v", err)
 	}
 	
 	qs := app.NewQuerySet(&User{})
@@ -175,13 +166,13 @@ func demonstrateQuerySet(app *gojango.App) {
 	
 	// 7. Actualizar usuarios inactivos
 	log.Println("\n7. Activando usuarios inactivos...")
-	err = qs.Filter("active", false).Update(map[string]interface{}{
+	updated, err := qs.Filter("active", false).Update(map[string]interface{}{
 		"active": true,
 	})
 	if err != nil {
 		log.Printf("Error: %v", err)
 	} else {
-		log.Println("Usuarios actualizados correctamente")
+		log.Printf("Usuarios actualizados: %d", updated)
 	}
 	
 	// 8. Complex query: active users aged between 20 and 35
@@ -13,7 +13,7 @@ type User struct {
 	models.Model
 	Name     string `json:"name" db:"name,not_null,size:100"`
 	Email    string `json:"email" db:"email,unique,not_null,size:255"`
-	Password string `json:"-" db:"password,not_null,size:255"`
+	Password string `json:"-" db:"password,not_null,size:255,hashed"`
 	Active   bool   `json:"active" db:"active,default:true"`
 }
 
@@ -68,7 +68,7 @@ func main() { // Create application with automatic configuration
 	// Custom routes (like Django URLs)
 	app.GET("/", homeHandler)
 	app.GET("/api/health", healthHandler)
-	app.POST("/api/login", loginHandler)
+	app.POST("/api/login", loginHandler(app))
 	app.GET("/api/users/:id/posts", userPostsHandler)
 
 	// Routes with specific middleware (temporary - without groups for now)
@@ -115,31 +115,37 @@ func healthHandler(c *gojango.Context) error {
 	})
 }
 
-func loginHandler(c *gojango.Context) error {
-	var loginData struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+func loginHandler(app *gojango.App) gojango.HandlerFunc {
+	return func(c *gojango.Context) error {
+		var loginData struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+
+		if err := c.BindJSON(&loginData); err != nil {
+			return c.ErrorJSON(400, "Invalid JSON", err)
+		}
+
+		if loginData.Email == "" || loginData.Password == "" {
+			return c.ErrorJSON(400, "Email and password required", nil)
+		}
+
+		// Password is tagged hashed, so the stored value is a bcrypt hash
+		found, err := app.NewQuerySet(&User{}).Filter("email", loginData.Email).First()
+		if err != nil || !models.CheckPassword(found.(*User).Password, loginData.Password) {
+			return c.ErrorJSON(401, "Invalid email or password", nil)
+		}
+
+		// Simulate JWT token
+		token := "fake-jwt-token-" + loginData.Email
+
+		return c.JSON(map[string]interface{}{
+			"token": token,
+			"user": map[string]string{
+				"email": loginData.Email,
+			},
+		})
 	}
-
-	if err := c.BindJSON(&loginData); err != nil {
-		return c.ErrorJSON(400, "Invalid JSON", err)
-	}
-
-	// Here you would implement authentication logic
-	// For simplicity, we accept any email/password
-	if loginData.Email == "" || loginData.Password == "" {
-		return c.ErrorJSON(400, "Email and password required", nil)
-	}
-
-	// Simulate JWT token
-	token := "fake-jwt-token-" + loginData.Email
-
-	return c.JSON(map[string]interface{}{
-		"token": token,
-		"user": map[string]string{
-			"email": loginData.Email,
-		},
-	})
 }
 
 func userPostsHandler(c *gojango.Context) error {
@@ -0,0 +1,137 @@
+package gojango
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gojango/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureRef is the key that names a fixture record, and that a field value
+// uses to refer to a named record's primary key
+const fixtureRef = "_ref"
+
+// LoadFixtures inserts the records of a JSON or YAML fixture file, like
+// Django's loaddata. The file maps model names, the table or struct name of a
+// model passed to AutoMigrate or RegisterCRUD, to lists of records keyed by
+// column:
+//
+//	users:
+//	  - _ref: alice
+//	    name: Alice
+//	    email: alice@example.com
+//	posts:
+//	  - title: Hello
+//	    author_id: {_ref: alice}
+//
+// Models and records are inserted in file order with DB.Create, so hooks and
+// password hashing run. A record with a _ref name can be linked to from later
+// records: {_ref: name} is replaced by that record's primary key. Everything
+// is loaded in one transaction; on error nothing is inserted.
+func (app *App) LoadFixtures(path string) error {
+	if app.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", ".yaml", ".yml":
+	default:
+		return fmt.Errorf("unsupported fixture file type %q", ext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read fixture file %s: %v", path, err)
+	}
+
+	// JSON is YAML, and decoding to nodes keeps the models in file order
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse fixture file %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("fixture file %s must map model names to lists of records", path)
+	}
+
+	loaded := 0
+	err = app.db.Transaction(func(tx *database.DB) error {
+		refs := make(map[string]interface{})
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			name := root.Content[i].Value
+			if _, ok := app.Model(name); !ok {
+				return fmt.Errorf("unknown fixture model %q; migrate or register it first", name)
+			}
+
+			var records []map[string]interface{}
+			if err := root.Content[i+1].Decode(&records); err != nil {
+				return fmt.Errorf("%s: records must be a list of objects: %v", name, err)
+			}
+
+			for n, record := range records {
+				model, _ := app.Model(name)
+				if err := loadFixture(tx, model, record, refs); err != nil {
+					return fmt.Errorf("%s record %d: %v", name, n+1, err)
+				}
+				loaded++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load fixtures from %s: %v", path, err)
+	}
+
+	log.Printf("Loaded %d fixtures from %s", loaded, path)
+	return nil
+}
+
+// loadFixture creates one fixture record, resolving its references and
+// recording its primary key if it is named
+func loadFixture(db *database.DB, model interface{}, record map[string]interface{}, refs map[string]interface{}) error {
+	label, named := record[fixtureRef].(string)
+	delete(record, fixtureRef)
+
+	for column, value := range record {
+		ref, ok := value.(map[string]interface{})
+		if !ok || len(ref) != 1 {
+			continue
+		}
+		target, ok := ref[fixtureRef].(string)
+		if !ok {
+			continue
+		}
+		pk, ok := refs[target]
+		if !ok {
+			return fmt.Errorf("column %s refers to unknown record %q", column, target)
+		}
+		record[column] = pk
+	}
+
+	if err := database.SetColumns(model, record); err != nil {
+		return err
+	}
+	if err := db.Create(model); err != nil {
+		return err
+	}
+
+	if named {
+		if _, taken := refs[label]; taken {
+			return fmt.Errorf("duplicate %s %q", fixtureRef, label)
+		}
+		pk, ok := primaryKey(model)
+		if !ok {
+			return fmt.Errorf("%s %q: %T has no primary key to refer to", fixtureRef, label, model)
+		}
+		refs[label] = pk
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package gojango
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Flash message levels, for styling messages in templates
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// FlashMessage is a one-time message for the next page the user sees
+type FlashMessage struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// flashCookie is the cookie flash messages are carried in between requests
+const flashCookie = "gojango_flash"
+
+// maxFlashCookie bounds the encoded cookie value; browsers drop cookies over
+// about 4KB
+const maxFlashCookie = 3800
+
+// flashState holds a request's flash messages
+type flashState struct {
+	// incoming were set by earlier requests; read is true once Flashes has
+	// returned them
+	incoming []FlashMessage
+	read     bool
+	// outgoing were added during this request
+	outgoing []FlashMessage
+}
+
+// Flash adds a one-time message, like Django's messages framework. It is
+// typically followed by a redirect, and shown by the page rendered next:
+//
+//	c.Flash(gojango.FlashSuccess, "Post saved")
+//	return c.Redirect(303, "/posts")
+//
+// Messages are kept in a cookie until read with Flashes or the flashes
+// template function. The cookie isn't signed, so users can read and alter
+// their own messages: don't put anything secret or trusted in them.
+func (c *Context) Flash(level, message string) {
+	state := c.flashState()
+	state.outgoing = append(state.outgoing, FlashMessage{Level: level, Message: message})
+	c.saveFlashes()
+}
+
+// Flashes returns the pending flash messages, oldest first, and clears them.
+// Templates rendered with Render read them with the flashes function:
+//
+//	{{range flashes}}<div class="alert alert-{{.Level}}">{{.Message}}</div>{{end}}
+func (c *Context) Flashes() []FlashMessage {
+	state := c.flashState()
+
+	var messages []FlashMessage
+	if !state.read {
+		messages = append(messages, state.incoming...)
+		state.read = true
+	}
+	messages = append(messages, state.outgoing...)
+	state.outgoing = nil
+
+	c.saveFlashes()
+	return messages
+}
+
+// flashState returns the request's flash messages, decoding the cookie the
+// first time
+func (c *Context) flashState() *flashState {
+	if c.flash != nil {
+		return c.flash
+	}
+
+	c.flash = &flashState{}
+	if cookie, err := c.Request.Cookie(flashCookie); err == nil {
+		if data, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil {
+			// A malformed cookie just carries no messages
+			json.Unmarshal(data, &c.flash.incoming)
+		}
+	}
+	return c.flash
+}
+
+// hasFlashes reports whether the request has messages Flashes would return,
+// without decoding the cookie when there is none
+func (c *Context) hasFlashes() bool {
+	if c.flash == nil {
+		_, err := c.Request.Cookie(flashCookie)
+		return err == nil
+	}
+	return (!c.flash.read && len(c.flash.incoming) > 0) || len(c.flash.outgoing) > 0
+}
+
+// saveFlashes sets the cookie to the unread messages, replacing one set
+// earlier in the request, or deletes it when none are left
+func (c *Context) saveFlashes() {
+	state := c.flash
+
+	var pending []FlashMessage
+	if !state.read {
+		pending = append(pending, state.incoming...)
+	}
+	pending = append(pending, state.outgoing...)
+
+	header := c.Response.Header()
+	cookies := header.Values("Set-Cookie")
+	header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		if !strings.HasPrefix(cookie, flashCookie+"=") {
+			header.Add("Set-Cookie", cookie)
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     flashCookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	for len(pending) > 0 {
+		data, _ := json.Marshal(pending)
+		cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+		if len(cookie.Value) <= maxFlashCookie {
+			break
+		}
+		// Drop the oldest messages until the rest fit
+		pending = pending[1:]
+	}
+
+	if len(pending) == 0 {
+		if _, err := c.Request.Cookie(flashCookie); err != nil {
+			return
+		}
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(c.Response, cookie)
+}
+
+// templateFuncs returns the request-scoped template functions, or nil when
+// the default ones will do
+func (c *Context) templateFuncs() template.FuncMap {
+	if !c.hasFlashes() {
+		return nil
+	}
+	return template.FuncMap{"flashes": c.Flashes}
+}
@@ -2,7 +2,19 @@ module github.com/sazardev/gojango
 
 go 1.22
 
-require github.com/mattn/go-sqlite3 v1.14.17
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/crypto v0.31.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
 
 // GoJango - Django-inspired web framework for Go
 // Minimal dependencies, batteries included
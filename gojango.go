@@ -3,15 +3,28 @@
 package gojango
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 
+	"gojango/cache"
 	"gojango/config"
 	"gojango/database"
+	"gojango/middleware"
+	"gojango/models"
 	"gojango/router"
 	"gojango/templates"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // App represents the main application instance
@@ -21,6 +34,28 @@ type App struct {
 	config     *config.Config
 	templates  *templates.Engine
 	middleware []Middleware
+	cache      cache.Cache
+
+	// serverMu guards server, which Run and Shutdown may reach from
+	// different goroutines
+	serverMu sync.Mutex
+	server   *http.Server
+
+	healthChecks []healthCheck
+	// crudResources are the models RegisterCRUD exposed, for OpenAPI
+	crudResources []crudResource
+	// registry maps the table and struct names of the models passed to
+	// AutoMigrate, RegisterCRUD and RegisterAdmin to their types; see Model
+	registry map[string]reflect.Type
+	// audit is set by EnableAuditLog
+	audit *auditLog
+	// dbMu guards db, dbURL and sqlLogging, which a config reload updates
+	// from the watcher's goroutine
+	dbMu sync.Mutex
+	// dbURL is the URL InitDB opened db with; empty when db came from WithDatabase
+	dbURL string
+	// sqlLogging records that debug mode installed the SQL logger
+	sqlLogging bool
 }
 
 // Context wraps HTTP request/response with useful methods
@@ -28,7 +63,17 @@ type Context struct {
 	Request  *http.Request
 	Response http.ResponseWriter
 	Params   map[string]string
+	values   map[string]interface{}
 	app      *App
+
+	// handlers is the middleware chain ending with the route handler;
+	// index is the position of the one currently running
+	handlers []Middleware
+	index    int
+	aborted  bool
+
+	// flash holds the request's flash messages once Flash or Flashes is used
+	flash *flashState
 }
 
 // Middleware defines the middleware function signature
@@ -50,15 +95,22 @@ func New(opts ...Option) *App {
 		opt(app)
 	}
 
-	// Initialize database if configured
-	if app.config.DatabaseURL != "" {
-		var err error
-		app.db, err = database.Connect(app.config.DatabaseURL)
-		if err != nil {
+	if app.cache == nil {
+		app.cache = cache.NewMemory(cache.DefaultMaxEntries)
+	}
+
+	// Initialize database if configured and none was supplied
+	if app.db == nil && app.config.DatabaseURL != "" {
+		if err := app.InitDB(); err != nil {
 			log.Fatalf("Failed to connect to database: %v", err)
 		}
 	}
 
+	app.setupDB()
+
+	// Re-read templates from disk on each render while developing
+	app.templates.SetAutoReload(app.config.GetDebug())
+
 	return app
 }
 
@@ -79,27 +131,77 @@ func WithDatabase(db *database.DB) Option {
 	}
 }
 
-// GET registers a GET route
-func (app *App) GET(path string, handler HandlerFunc) {
-	app.router.GET(path, app.wrapHandler(handler))
+// WithCache sets the cache returned by App.Cache and used by
+// middleware.CacheResponse, such as cache.NewRedis. The default is an
+// in-memory LRU cache of cache.DefaultMaxEntries entries.
+func WithCache(c cache.Cache) Option {
+	return func(app *App) {
+		app.cache = c
+	}
+}
+
+// GET registers a GET route. Optional middleware applies to this route only
+// and runs after the global (and group) middleware, just before the handler.
+func (app *App) GET(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.GET(path, app.wrapHandler(handler, nil, middleware))
 }
 
 // POST registers a POST route
-func (app *App) POST(path string, handler HandlerFunc) {
-	app.router.POST(path, app.wrapHandler(handler))
+func (app *App) POST(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.POST(path, app.wrapHandler(handler, nil, middleware))
 }
 
 // PUT registers a PUT route
-func (app *App) PUT(path string, handler HandlerFunc) {
-	app.router.PUT(path, app.wrapHandler(handler))
+func (app *App) PUT(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.PUT(path, app.wrapHandler(handler, nil, middleware))
 }
 
 // DELETE registers a DELETE route
-func (app *App) DELETE(path string, handler HandlerFunc) {
-	app.router.DELETE(path, app.wrapHandler(handler))
+func (app *App) DELETE(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.DELETE(path, app.wrapHandler(handler, nil, middleware))
+}
+
+// PATCH registers a PATCH route
+func (app *App) PATCH(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.PATCH(path, app.wrapHandler(handler, nil, middleware))
+}
+
+// OPTIONS registers an OPTIONS route, such as a CORS preflight handler
+func (app *App) OPTIONS(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	return app.router.OPTIONS(path, app.wrapHandler(handler, nil, middleware))
+}
+
+// URL returns the path of a named route with its parameters filled in,
+// like Django's reverse():
+//
+//	app.GET("/users/:id", showUser).Name("user-detail")
+//	path, err := app.URL("user-detail", map[string]string{"id": "5"}) // "/users/5"
+func (app *App) URL(name string, params map[string]string) (string, error) {
+	return app.router.URL(name, params)
+}
+
+// Routes returns every registered route (method, pattern, parameter names
+// and name) in registration order, including those added by groups,
+// RegisterCRUD and RegisterAdmin
+func (app *App) Routes() []router.RouteInfo {
+	return app.router.Routes()
+}
+
+// RoutesHandler lists the registered routes as JSON, for development:
+//
+//	if app.GetConfig().Debug {
+//		app.GET("/__routes", app.RoutesHandler())
+//	}
+func (app *App) RoutesHandler() HandlerFunc {
+	return func(c *Context) error {
+		return c.JSON(app.Routes())
+	}
 }
 
-// Use adds middleware to the application
+// Use adds middleware to the application. Middleware runs in this order:
+// global middleware in registration order, then group middleware (outermost
+// group first), then per-route middleware, then the handler. The first
+// middleware returning an error stops the chain.
 func (app *App) Use(middleware Middleware) {
 	app.middleware = append(app.middleware, middleware)
 }
@@ -111,123 +213,393 @@ func (app *App) AutoMigrate(models ...interface{}) error {
 	}
 
 	for _, model := range models {
-		if err := app.db.AutoMigrate(model); err != nil {
+		app.registerModel(model)
+
+		added, err := app.db.MigrateModel(model)
+		if err != nil {
 			return fmt.Errorf("failed to migrate %T: %v", model, err)
 		}
+
+		if len(added) > 0 {
+			log.Printf("Added columns to %s: %s", app.db.GetTableName(model), strings.Join(added, ", "))
+		}
 	}
 
 	return nil
 }
 
-// RegisterCRUD automatically creates CRUD endpoints for a model
-func (app *App) RegisterCRUD(basePath string, model interface{}) {
-	modelType := reflect.TypeOf(model)
-	if modelType.Kind() == reflect.Ptr {
-		modelType = modelType.Elem()
+// RegisterCRUD automatically creates CRUD endpoints for a model. Responses
+// use the model's models.Serializer implementation if it has one, or the
+// field sets given with IncludeFields and ExcludeFields.
+func (app *App) RegisterCRUD(basePath string, model interface{}, opts ...CRUDOption) {
+	modelType := app.registerModel(model)
+
+	options := &crudOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	app.crudResources = append(app.crudResources, crudResource{basePath: basePath, modelType: modelType, options: options})
+
+	// respond writes a single record in the detail view
+	respond := func(c *Context, status int, record interface{}) error {
+		data, err := options.serialize(record, models.ViewDetail)
+		if err != nil {
+			return c.ErrorJSON(500, "Serialization error", err)
+		}
+		return c.JSONStatus(status, data)
 	}
 
 	// List endpoint
 	app.GET(basePath, func(c *Context) error {
-		results, err := app.db.FindAll(model)
+		results, err := app.db.FindAllContext(c.Request.Context(), model)
 		if err != nil {
 			return c.ErrorJSON(500, "Database error", err)
 		}
-		return c.JSON(results)
+
+		data, err := options.serializeList(results)
+		if err != nil {
+			return c.ErrorJSON(500, "Serialization error", err)
+		}
+		return c.JSON(data)
 	})
 
 	// Create endpoint
 	app.POST(basePath, func(c *Context) error {
-		newModel := reflect.New(modelType).Interface()
+		newModel := newModel(modelType)
 		if err := c.BindJSON(newModel); err != nil {
 			return c.ErrorJSON(400, "Invalid JSON", err)
 		}
 
-		if err := app.db.Create(newModel); err != nil {
+		if errs := models.Validate(newModel); len(errs) > 0 {
+			return c.ErrorJSON(400, "Validation failed", errs)
+		}
+
+		if err := app.db.CreateContext(c.Request.Context(), newModel); err != nil {
 			return c.ErrorJSON(500, "Database error", err)
 		}
 
-		return c.JSON(newModel)
+		if id, ok := primaryKey(newModel); ok {
+			c.Header("Location", basePath+"/"+url.PathEscape(id))
+		}
+		return respond(c, http.StatusCreated, newModel)
 	})
 
 	// Get by ID endpoint
 	app.GET(basePath+"/:id", func(c *Context) error {
 		id := c.Param("id")
-		result := reflect.New(modelType).Interface()
+		result := newModel(modelType)
 
-		if err := app.db.FindByID(result, id); err != nil {
-			return c.ErrorJSON(404, "Not found", err)
+		if err := app.db.FindByIDContext(c.Request.Context(), result, id); err != nil {
+			return c.dbError(err)
 		}
 
-		return c.JSON(result)
+		return respond(c, http.StatusOK, result)
 	})
 
-	// Update endpoint
-	app.PUT(basePath+"/:id", func(c *Context) error {
+	// Update endpoints: only the fields present in the body are changed, so
+	// omitted fields keep their stored values
+	update := func(c *Context) error {
 		id := c.Param("id")
-		updateModel := reflect.New(modelType).Interface()
 
-		if err := c.BindJSON(updateModel); err != nil {
+		var body map[string]json.RawMessage
+		if err := c.BindJSON(&body); err != nil {
 			return c.ErrorJSON(400, "Invalid JSON", err)
 		}
 
-		if err := app.db.Update(updateModel, id); err != nil {
-			return c.ErrorJSON(500, "Database error", err)
+		record := newModel(modelType)
+		if err := app.db.FindByIDContext(c.Request.Context(), record, id); err != nil {
+			return c.dbError(err)
 		}
 
-		return c.JSON(updateModel)
-	})
+		// Apply the writable fields that were sent on top of the stored record
+		fields, columns := writableFields(record, body)
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return c.ErrorJSON(400, "Invalid JSON", err)
+		}
+		if err := json.Unmarshal(data, record); err != nil {
+			return c.ErrorJSON(400, "Invalid JSON", err)
+		}
+
+		if errs := models.Validate(record); len(errs) > 0 {
+			return c.ErrorJSON(400, "Validation failed", errs)
+		}
+
+		if len(columns) > 0 {
+			if err := app.db.UpdateFieldsContext(c.Request.Context(), record, id, columns...); err != nil {
+				return c.dbError(err)
+			}
+		}
+
+		return respond(c, http.StatusOK, record)
+	}
+	app.PUT(basePath+"/:id", update)
+	app.PATCH(basePath+"/:id", update)
 
 	// Delete endpoint
 	app.DELETE(basePath+"/:id", func(c *Context) error {
 		id := c.Param("id")
-		deleteModel := reflect.New(modelType).Interface()
+		deleteModel := newModel(modelType)
 
-		if err := app.db.Delete(deleteModel, id); err != nil {
-			return c.ErrorJSON(500, "Database error", err)
+		if err := app.db.DeleteContext(c.Request.Context(), deleteModel, id); err != nil {
+			return c.dbError(err)
 		}
 
 		return c.JSON(map[string]string{"message": "Deleted successfully"})
 	})
+
+	// Bulk delete endpoint
+	if options.bulkDelete {
+		// The ids are values of the primary key column, id when the model
+		// declares none
+		keyColumn := "id"
+		var keyColumns int
+		for _, column := range database.Columns(model) {
+			if column.PrimaryKey {
+				keyColumn = column.Name
+				keyColumns++
+			}
+		}
+
+		app.DELETE(basePath, func(c *Context) error {
+			if keyColumns > 1 {
+				return c.ErrorJSON(400, "Bulk delete needs a single-column primary key", nil)
+			}
+
+			var body struct {
+				IDs []interface{} `json:"ids"`
+			}
+			if err := c.BindJSON(&body); err != nil {
+				return c.ErrorJSON(400, "Invalid JSON", err)
+			}
+			if len(body.IDs) == 0 {
+				return c.ErrorJSON(400, "No ids given", nil)
+			}
+
+			deleted, err := app.NewQuerySet(model).WithContext(c.Request.Context()).Filter(keyColumn+"__in", body.IDs).Delete()
+			if err != nil {
+				return c.dbError(err)
+			}
+
+			return c.JSON(map[string]int64{"deleted": deleted})
+		})
+	}
+
+	// Preflight endpoints, so browsers can call the API cross-origin
+	listMethods := "GET, POST, OPTIONS"
+	if options.bulkDelete {
+		listMethods = "GET, POST, DELETE, OPTIONS"
+	}
+	app.OPTIONS(basePath, preflight(listMethods))
+	app.OPTIONS(basePath+"/:id", preflight("GET, PUT, PATCH, DELETE, OPTIONS"))
 }
 
-// InitDB initializes the database connection using the current config
+// preflight answers an OPTIONS request with 204, the allowed methods and
+// CORS headers. An Access-Control-Allow-Origin already set by middleware
+// (such as middleware.CORS) is kept; otherwise any origin is allowed.
+func preflight(methods string) HandlerFunc {
+	return func(c *Context) error {
+		header := c.Response.Header()
+		header.Set("Allow", methods)
+		header.Set("Access-Control-Allow-Methods", methods)
+		if header.Get("Access-Control-Allow-Origin") == "" {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		if header.Get("Access-Control-Allow-Headers") == "" {
+			header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
+		if header.Get("Access-Control-Max-Age") == "" {
+			header.Set("Access-Control-Max-Age", "3600")
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// primaryKey returns the primary key value of a model as text
+func primaryKey(model interface{}) (string, bool) {
+	value := reflect.Indirect(reflect.ValueOf(model))
+	for _, column := range database.Columns(model) {
+		if column.PrimaryKey {
+			return fmt.Sprint(value.FieldByIndex(column.Index).Interface()), true
+		}
+	}
+	return "", false
+}
+
+// writableFields picks the entries of a JSON body that set a non-key column
+// of model, returning them with the matching column names
+func writableFields(model interface{}, body map[string]json.RawMessage) (map[string]json.RawMessage, []string) {
+	fields := make(map[string]json.RawMessage)
+	var columns []string
+
+	for _, column := range database.Columns(model) {
+		if column.PrimaryKey || column.AutoIncrement {
+			continue
+		}
+
+		name := strings.Split(column.Field.Tag.Get(models.TagJSON), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = column.Field.Name
+		}
+
+		if value, ok := body[name]; ok {
+			fields[name] = value
+			columns = append(columns, column.Name)
+		}
+	}
+	return fields, columns
+}
+
+// InitDB connects to the database at config.DatabaseURL. It is safe to call
+// repeatedly: when the app is already connected to that URL it does nothing,
+// otherwise the new connection replaces the old one, which is closed if
+// InitDB opened it (a database passed with WithDatabase is left to its owner).
 func (app *App) InitDB() error {
-	if app.config.DatabaseURL == "" {
+	app.dbMu.Lock()
+	defer app.dbMu.Unlock()
+
+	url := app.config.GetDatabaseURL()
+	if url == "" {
 		return fmt.Errorf("database URL not configured")
 	}
 
+	if app.db != nil && app.dbURL == url {
+		return nil
+	}
+
+	var db *database.DB
 	var err error
 
 	// Use mock database for testing
-	if app.config.DatabaseURL == "mock://" {
-		app.db, err = database.ConnectMock()
+	if url == "mock://" {
+		db, err = database.ConnectMock()
 	} else {
-		app.db, err = database.Connect(app.config.DatabaseURL)
+		db, err = database.ConnectWithRetry(url, app.config.ConnectAttempts, app.config.ConnectBackoff, app.databaseOptions()...)
 	}
 
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
 
+	for _, replicaURL := range app.config.ReplicaURLs {
+		if err := db.AddReplica(replicaURL, app.databaseOptions()...); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	if app.db != nil && app.dbURL != "" {
+		if err := app.db.Close(); err != nil {
+			log.Printf("Failed to close previous database connection: %v", err)
+		}
+	}
+
+	app.db, app.dbURL = db, url
+	app.sqlLogging = false
+	app.setupDBLocked()
+
 	return nil
 }
 
-// GetDB returns the database instance (for advanced usage)
+// CloseDB closes the database connection and detaches it from the app, so
+// InitDB can connect again later. It does nothing when there is no connection.
+func (app *App) CloseDB() error {
+	app.dbMu.Lock()
+	defer app.dbMu.Unlock()
+
+	if app.db == nil {
+		return nil
+	}
+
+	db := app.db
+	app.db, app.dbURL = nil, ""
+	app.sqlLogging = false
+	return db.Close()
+}
+
+// setupDB applies config-dependent settings to the database connection.
+// In debug mode every SQL statement is logged with its arguments and timing.
+func (app *App) setupDB() {
+	app.dbMu.Lock()
+	defer app.dbMu.Unlock()
+	app.setupDBLocked()
+}
+
+// setupDBLocked is setupDB for callers holding app.dbMu
+func (app *App) setupDBLocked() {
+	if app.db == nil {
+		return
+	}
+
+	if app.config.GetDebug() {
+		app.db.SetLogger(database.StdLogger)
+		app.sqlLogging = true
+	} else if app.sqlLogging {
+		// Only remove the logger debug mode installed, not a custom one
+		app.db.SetLogger(nil)
+		app.sqlLogging = false
+	}
+}
+
+// WatchConfig reloads the config file at path whenever it changes and
+// re-applies the settings that depend on Config.Debug (SQL logging and
+// template auto-reload). onChange callbacks run after each reload.
+func (app *App) WatchConfig(path string, onChange ...func(*config.Config)) (*config.Watcher, error) {
+	return app.config.Watch(path, func(cfg *config.Config) {
+		app.setupDB()
+		app.templates.SetAutoReload(cfg.GetDebug())
+
+		for _, fn := range onChange {
+			fn(cfg)
+		}
+	})
+}
+
+// databaseOptions builds the connection pool options from the config
+func (app *App) databaseOptions() []database.Option {
+	return []database.Option{
+		database.WithMaxOpenConns(app.config.MaxOpenConns),
+		database.WithMaxIdleConns(app.config.MaxIdleConns),
+		database.WithConnMaxLifetime(app.config.ConnMaxLifetime),
+		database.WithBusyTimeout(app.config.BusyTimeout),
+	}
+}
+
+// GetDB returns the database connection, or nil before InitDB (or
+// WithDatabase) provides one. Use it for queries the app doesn't wrap.
 func (app *App) GetDB() *database.DB {
 	return app.db
 }
 
-// GetConfig returns the configuration instance
+// GetConfig returns the configuration the app was created with. Changes
+// to DatabaseURL take effect on the next InitDB.
 func (app *App) GetConfig() *config.Config {
 	return app.config
 }
 
-// GetRouter returns the router instance (for testing)
+// GetRouter returns the router. It is an http.Handler for every registered
+// route, so it can be served directly or passed to httptest.NewServer.
 func (app *App) GetRouter() *router.Router {
 	return app.router
 }
 
-// GetTemplates returns the template engine instance
+// Cache returns the app's cache, for handlers that cache expensive results:
+//
+//	var stats Stats
+//	if ok, _ := cache.GetJSON(app.Cache(), "stats", &stats); !ok {
+//		stats = computeStats()
+//		cache.SetJSON(app.Cache(), "stats", stats, time.Minute)
+//	}
+func (app *App) Cache() cache.Cache {
+	return app.cache
+}
+
+// GetTemplates returns the template engine, so templates can be configured
+// (SetBaseDir, AddFunc, LoadTemplates) before Run.
 func (app *App) GetTemplates() *templates.Engine {
 	return app.templates
 }
@@ -237,45 +609,196 @@ func (app *App) NewQuerySet(model interface{}) *QuerySet {
 	return NewQuerySet(app.db, model)
 }
 
+// Server returns the http.Server used by Run, RunTLS and RunAutoTLS, so
+// its timeouts, TLS settings or error log can be customized before starting.
+// Timeouts are taken from the config when the server is first created.
+func (app *App) Server() *http.Server {
+	app.serverMu.Lock()
+	defer app.serverMu.Unlock()
+	return app.serverLocked()
+}
+
+// listenOn returns the server with its address set to addr
+func (app *App) listenOn(addr string) *http.Server {
+	app.serverMu.Lock()
+	defer app.serverMu.Unlock()
+	server := app.serverLocked()
+	server.Addr = addr
+	return server
+}
+
+// serverLocked creates the server on first use; the caller holds serverMu
+func (app *App) serverLocked() *http.Server {
+	if app.server == nil {
+		app.server = &http.Server{
+			Handler:           app.router,
+			ReadTimeout:       app.config.ReadTimeout,
+			WriteTimeout:      app.config.WriteTimeout,
+			IdleTimeout:       app.config.IdleTimeout,
+			ReadHeaderTimeout: app.config.ReadHeaderTimeout,
+		}
+	}
+	return app.server
+}
+
 // Run starts the HTTP server
 func (app *App) Run(addr string) error {
 	if addr == "" {
 		addr = app.config.GetString("server.port", ":8000")
 	}
 
+	server := app.listenOn(addr)
+
 	log.Printf("🚀 GoJango server starting on %s", addr)
-	return http.ListenAndServe(addr, app.router)
+	return app.serve(server.ListenAndServe)
+}
+
+// RunTLS starts the HTTPS server with the given certificate and key files
+func (app *App) RunTLS(addr, certFile, keyFile string) error {
+	if addr == "" {
+		addr = app.config.GetString("server.tls_port", ":443")
+	}
+
+	server := app.listenOn(addr)
+
+	log.Printf("🔒 GoJango server starting on %s (TLS)", addr)
+	return app.serve(func() error {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// RunAutoTLS starts the HTTPS server on :443 with certificates obtained from
+// Let's Encrypt for the given domains. Certificates are cached in the
+// directory set by the "autotls.cache_dir" setting (default "certs").
+// Port 80 answers ACME challenges and redirects everything else to HTTPS.
+func (app *App) RunAutoTLS(domains ...string) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("at least one domain is required for AutoTLS")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(app.config.GetString("autotls.cache_dir", "certs")),
+	}
+
+	app.serverMu.Lock()
+	server := app.serverLocked()
+	server.Addr = ":443"
+	server.TLSConfig = manager.TLSConfig()
+	app.serverMu.Unlock()
+
+	go func() {
+		if err := http.ListenAndServe(":80", manager.HTTPHandler(nil)); err != nil {
+			log.Printf("AutoTLS challenge server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("🔒 GoJango server starting on :443 for %s (AutoTLS)", strings.Join(domains, ", "))
+	return app.serve(func() error {
+		return server.ListenAndServeTLS("", "")
+	})
+}
+
+// serve runs listen until it fails or the process receives SIGINT or
+// SIGTERM. On a signal the app shuts down gracefully, giving in-flight
+// requests up to config.ShutdownTimeout, and serve returns nil.
+func (app *App) serve(listen func() error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() { errs <- listen() }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		stop()
+	}
+
+	log.Printf("Shutting down...")
+
+	shutdownCtx := context.Background()
+	if timeout := app.config.ShutdownTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, timeout)
+		defer cancel()
+	}
+
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown failed: %v", err)
+	}
+	return nil
 }
 
-// wrapHandler wraps a HandlerFunc to work with the router
-func (app *App) wrapHandler(handler HandlerFunc) http.HandlerFunc {
+// Shutdown gracefully stops the server started by Run, RunTLS or RunAutoTLS,
+// waiting for in-flight requests until ctx is done, and then closes the
+// database connection. The Run call returns http.ErrServerClosed.
+func (app *App) Shutdown(ctx context.Context) error {
+	err := app.Server().Shutdown(ctx)
+	if dbErr := app.CloseDB(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// MetricsHandler serves the metrics collected by middleware.Metrics in the
+// Prometheus text format:
+//
+//	app.Use(func(c *gojango.Context) error { return middleware.Metrics()(c) })
+//	app.GET("/metrics", app.MetricsHandler())
+func (app *App) MetricsHandler() HandlerFunc {
+	return func(c *Context) error {
+		c.Header("Content-Type", middleware.MetricsContentType)
+		return middleware.DefaultMetricsRegistry.Write(c.Response)
+	}
+}
+
+// wrapHandler wraps a HandlerFunc to work with the router. Each request runs
+// the global middleware, the group's middleware (if any), the route
+// middleware and finally the handler as one chain; see Context.Next.
+func (app *App) wrapHandler(handler HandlerFunc, group *RouteGroup, middleware []Middleware) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWriter(w)
+		defer rw.writeHeaderNow()
+
 		ctx := &Context{
 			Request:  r,
-			Response: w,
+			Response: rw,
 			Params:   make(map[string]string),
 			app:      app,
 		}
 
-		// Extract route parameters from header (set by router)
-		if paramHeader := r.Header.Get("X-Route-Params"); paramHeader != "" {
-			r.Header.Del("X-Route-Params") // Clean up
-			for k, v := range router.DecodeParams(paramHeader) {
-				ctx.Params[k] = v
-			}
+		// Route parameters matched by the router
+		for k, v := range router.Params(r) {
+			ctx.Params[k] = v
 		}
 
-		// Execute middleware chain
-		for _, middleware := range app.middleware {
-			if err := middleware(ctx); err != nil {
-				ctx.ErrorJSON(500, "Middleware error", err)
-				return
-			}
+		// Let the audit log find the request behind a change
+		if app.audit != nil {
+			ctx.Request = r.WithContext(context.WithValue(r.Context(), requestContextKey{}, ctx))
+		}
+
+		// Build the chain; global and group middleware are read per request
+		// so Use calls made after route registration still apply
+		chain := make([]Middleware, 0, len(app.middleware)+len(middleware)+1)
+		chain = append(chain, app.middleware...)
+		if group != nil {
+			chain = group.appendMiddleware(chain)
 		}
+		chain = append(chain, middleware...)
+		chain = append(chain, Middleware(handler))
+
+		ctx.handlers = chain
+		ctx.index = -1
 
-		// Execute handler
-		if err := handler(ctx); err != nil {
-			ctx.ErrorJSON(500, "Handler error", err)
+		if err := ctx.Next(); err != nil {
+			if ctx.index >= len(chain)-1 {
+				ctx.ErrorJSON(500, "Handler error", err)
+			} else {
+				ctx.ErrorJSON(500, "Middleware error", err)
+			}
 		}
 	}
 }
@@ -283,6 +806,7 @@ func (app *App) wrapHandler(handler HandlerFunc) http.HandlerFunc {
 // RouteGroup allows grouping routes with common middleware
 type RouteGroup struct {
 	app        *App
+	parent     *RouteGroup
 	prefix     string
 	middleware []Middleware
 }
@@ -296,50 +820,65 @@ func (app *App) Group(prefix string) *RouteGroup {
 	}
 }
 
+// Group creates a nested group under rg's prefix. The nested group runs
+// rg's middleware (including any added later) before its own.
+//
+//	api := app.Group("/api/v1")
+//	admin := api.Group("/admin") // routes under /api/v1/admin
+func (rg *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{
+		app:        rg.app,
+		parent:     rg,
+		prefix:     rg.prefix + prefix,
+		middleware: make([]Middleware, 0),
+	}
+}
+
 // Use adds middleware to the route group
 func (rg *RouteGroup) Use(middleware Middleware) {
 	rg.middleware = append(rg.middleware, middleware)
 }
 
 // GET registers a GET route in the group
-func (rg *RouteGroup) GET(path string, handler HandlerFunc) {
+func (rg *RouteGroup) GET(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
 	fullPath := rg.prefix + path
-	wrappedHandler := rg.wrapWithGroupMiddleware(handler)
-	rg.app.router.GET(fullPath, rg.app.wrapHandler(wrappedHandler))
+	return rg.app.router.GET(fullPath, rg.app.wrapHandler(handler, rg, middleware))
 }
 
 // POST registers a POST route in the group
-func (rg *RouteGroup) POST(path string, handler HandlerFunc) {
+func (rg *RouteGroup) POST(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
 	fullPath := rg.prefix + path
-	wrappedHandler := rg.wrapWithGroupMiddleware(handler)
-	rg.app.router.POST(fullPath, rg.app.wrapHandler(wrappedHandler))
+	return rg.app.router.POST(fullPath, rg.app.wrapHandler(handler, rg, middleware))
 }
 
 // PUT registers a PUT route in the group
-func (rg *RouteGroup) PUT(path string, handler HandlerFunc) {
+func (rg *RouteGroup) PUT(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
 	fullPath := rg.prefix + path
-	wrappedHandler := rg.wrapWithGroupMiddleware(handler)
-	rg.app.router.PUT(fullPath, rg.app.wrapHandler(wrappedHandler))
+	return rg.app.router.PUT(fullPath, rg.app.wrapHandler(handler, rg, middleware))
 }
 
 // DELETE registers a DELETE route in the group
-func (rg *RouteGroup) DELETE(path string, handler HandlerFunc) {
+func (rg *RouteGroup) DELETE(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
 	fullPath := rg.prefix + path
-	wrappedHandler := rg.wrapWithGroupMiddleware(handler)
-	rg.app.router.DELETE(fullPath, rg.app.wrapHandler(wrappedHandler))
+	return rg.app.router.DELETE(fullPath, rg.app.wrapHandler(handler, rg, middleware))
 }
 
-// wrapWithGroupMiddleware wraps handler with group-specific middleware
-func (rg *RouteGroup) wrapWithGroupMiddleware(handler HandlerFunc) HandlerFunc {
-	return func(c *Context) error {
-		// Execute group middleware first
-		for _, middleware := range rg.middleware {
-			if err := middleware(c); err != nil {
-				return err
-			}
-		}
+// PATCH registers a PATCH route in the group
+func (rg *RouteGroup) PATCH(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	fullPath := rg.prefix + path
+	return rg.app.router.PATCH(fullPath, rg.app.wrapHandler(handler, rg, middleware))
+}
+
+// OPTIONS registers an OPTIONS route in the group
+func (rg *RouteGroup) OPTIONS(path string, handler HandlerFunc, middleware ...Middleware) *router.Route {
+	fullPath := rg.prefix + path
+	return rg.app.router.OPTIONS(fullPath, rg.app.wrapHandler(handler, rg, middleware))
+}
 
-		// Then execute the handler
-		return handler(c)
+// appendMiddleware appends the parent groups' middleware, then rg's own
+func (rg *RouteGroup) appendMiddleware(chain []Middleware) []Middleware {
+	if rg.parent != nil {
+		chain = rg.parent.appendMiddleware(chain)
 	}
+	return append(chain, rg.middleware...)
 }
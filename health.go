@@ -0,0 +1,89 @@
+package gojango
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthCheck is a named readiness check
+type healthCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// healthResult is the JSON reported for a single check
+type healthResult struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// AddHealthCheck registers a readiness check, such as a cache or queue ping.
+// Checks run concurrently on each readiness request.
+func (app *App) AddHealthCheck(name string, fn func(ctx context.Context) error) {
+	app.healthChecks = append(app.healthChecks, healthCheck{name: name, fn: fn})
+}
+
+// HealthCheck registers the readiness endpoint at path and a liveness
+// endpoint at path+"/live". Readiness runs every check (plus a database
+// ping when a database is configured) and answers 503 if any fails:
+//
+//	{"status": "ok", "checks": {"database": {"status": "ok", "latency_ms": 0.4}}}
+//
+// Liveness always answers 200 while the process is serving requests.
+func (app *App) HealthCheck(path string) {
+	app.GET(path, app.readinessHandler)
+	app.GET(path+"/live", func(c *Context) error {
+		return c.JSON(map[string]string{"status": "ok"})
+	})
+}
+
+// readinessHandler runs the health checks and reports their results
+func (app *App) readinessHandler(c *Context) error {
+	checks := app.healthChecks
+	if app.db != nil {
+		checks = append([]healthCheck{{name: "database", fn: app.db.Ping}}, checks...)
+	}
+
+	results := make(map[string]healthResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check.fn(c.Request.Context())
+			result := healthResult{
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[check.name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			status, code = "error", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	return c.JSONStatus(code, map[string]interface{}{
+		"status": status,
+		"checks": results,
+	})
+}
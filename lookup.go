@@ -0,0 +1,286 @@
+package gojango
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gojango/database"
+)
+
+// lookup is a Filter or Exclude call: a Django-style "field__lookup" and the
+// value it compares against
+type lookup struct {
+	field   string
+	value   interface{}
+	exclude bool
+}
+
+// mockRecords runs the query on the mock database: the records its lookups
+// match, ordered, offset and limited as the SQL would be, and restricted to
+// the columns Only or Defer selected
+func (qs *QuerySet) mockRecords(mock *database.MockDB) []map[string]interface{} {
+	records := mock.Find(qs.tableName, qs.matches)
+
+	if qs.orderBy != "" {
+		column, direction, _ := strings.Cut(qs.orderBy, " ")
+		descending := direction == "DESC"
+		sort.SliceStable(records, func(i, j int) bool {
+			a, b := indirect(records[i][column]), indirect(records[j][column])
+			// NULLs come first in ascending order, as on SQLite and MySQL
+			if a == nil || b == nil {
+				if descending {
+					return a != nil && b == nil
+				}
+				return a == nil && b != nil
+			}
+			c, ok := compareValues(a, b)
+			if !ok {
+				c = strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+			}
+			if descending {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	if qs.offset >= len(records) {
+		records = nil
+	} else if qs.offset > 0 {
+		records = records[qs.offset:]
+	}
+	if qs.limit > 0 && len(records) > qs.limit {
+		records = records[:qs.limit]
+	}
+
+	if len(qs.columns) > 0 {
+		for i, record := range records {
+			selected := make(map[string]interface{}, len(qs.columns))
+			for _, column := range qs.columns {
+				selected[column] = record[column]
+			}
+			records[i] = selected
+		}
+	}
+	return records
+}
+
+// mockModels converts mock records to a slice of pointers to model's type
+func mockModels(records []map[string]interface{}, model interface{}) (interface{}, error) {
+	modelType := structType(model)
+	results := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(modelType)), 0, len(records))
+	for _, record := range records {
+		result := newModel(modelType)
+		if err := setMockColumns(result, record); err != nil {
+			return nil, err
+		}
+		results = reflect.Append(results, reflect.ValueOf(result))
+	}
+	return results.Interface(), nil
+}
+
+// setMockColumns sets the fields of model from a mock record, skipping the
+// columns model doesn't map, as scanning a row does
+func setMockColumns(model interface{}, record map[string]interface{}) error {
+	values := make(map[string]interface{}, len(record))
+	for _, column := range database.Columns(model) {
+		if value, ok := record[column.Name]; ok {
+			values[column.Name] = value
+		}
+	}
+	return database.SetColumns(model, values)
+}
+
+// matches reports whether a mock database record satisfies every lookup of
+// qs, evaluating them in memory the way the SQL conditions would run
+func (qs *QuerySet) matches(record map[string]interface{}) bool {
+	for _, l := range qs.lookups {
+		matched, null := l.match(record)
+		// As in SQL, a comparison with NULL is neither true nor false, so
+		// the record is left out by Filter and Exclude alike
+		if null || matched == l.exclude {
+			return false
+		}
+	}
+	return true
+}
+
+// match evaluates the lookup, ignoring exclude, against record. null is true
+// when the column is NULL and the lookup isn't isnull.
+func (l lookup) match(record map[string]interface{}) (matched, null bool) {
+	name, op := l.field, "exact"
+	if i := strings.Index(l.field, "__"); i >= 0 {
+		name, op = l.field[:i], l.field[i+2:]
+	}
+
+	stored, ok := record[name]
+	stored = indirect(stored)
+	if op == "isnull" {
+		isNull, _ := l.value.(bool)
+		return isNull == (!ok || stored == nil), false
+	}
+	if !ok || stored == nil {
+		return false, true
+	}
+
+	text := fmt.Sprintf("%v", stored)
+	switch op {
+	case "exact":
+		return equalValues(stored, l.value), false
+	case "iexact":
+		return strings.EqualFold(text, fmt.Sprintf("%v", l.value)), false
+	case "contains":
+		return strings.Contains(text, fmt.Sprintf("%v", l.value)), false
+	case "icontains":
+		return strings.Contains(strings.ToLower(text), strings.ToLower(fmt.Sprintf("%v", l.value))), false
+	case "startswith":
+		return strings.HasPrefix(text, fmt.Sprintf("%v", l.value)), false
+	case "endswith":
+		return strings.HasSuffix(text, fmt.Sprintf("%v", l.value)), false
+	case "gt", "gte", "lt", "lte":
+		c, ok := compareValues(stored, l.value)
+		if !ok {
+			return false, false
+		}
+		switch op {
+		case "gt":
+			return c > 0, false
+		case "gte":
+			return c >= 0, false
+		case "lt":
+			return c < 0, false
+		}
+		return c <= 0, false
+	case "in":
+		list := reflect.ValueOf(l.value)
+		for i := 0; i < list.Len(); i++ {
+			if equalValues(stored, list.Index(i).Interface()) {
+				return true, false
+			}
+		}
+		return false, false
+	case "range":
+		pair := reflect.ValueOf(l.value)
+		low, lowOK := compareValues(stored, pair.Index(0).Interface())
+		high, highOK := compareValues(stored, pair.Index(1).Interface())
+		return lowOK && highOK && low >= 0 && high <= 0, false
+	case "date":
+		day, _ := lookupDate(l.value)
+		if t, ok := stored.(time.Time); ok {
+			return t.Format("2006-01-02") == day, false
+		}
+		return strings.HasPrefix(text, day), false
+	case "year":
+		year, _ := lookupYear(l.value)
+		if t, ok := stored.(time.Time); ok {
+			return t.Year() == year, false
+		}
+		return strings.HasPrefix(text, fmt.Sprintf("%04d", year)), false
+	case "regex":
+		matched, err := regexp.MatchString(fmt.Sprintf("%v", l.value), text)
+		return err == nil && matched, false
+	}
+	return false, false
+}
+
+// indirect dereferences pointers and unwraps driver.Valuer types such as
+// sql.NullString, returning nil for NULL
+func indirect(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil
+		}
+		unwrapped, err := valuer.Value()
+		if err != nil {
+			return value
+		}
+		return unwrapped
+	}
+
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// equalValues compares two values the way a database would: numbers by
+// value, times as instants, anything else by its text
+func equalValues(a, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", indirect(b))
+}
+
+// compareValues orders a against b, reporting false when they aren't both
+// numbers, both times (a time may be compared with an RFC 3339 or
+// YYYY-MM-DD string) or both strings
+func compareValues(a, b interface{}) (int, bool) {
+	b = indirect(b)
+
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if t, ok := a.(time.Time); ok {
+		var other time.Time
+		switch v := b.(type) {
+		case time.Time:
+			other = v
+		case string:
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				if parsed, err = time.Parse("2006-01-02", v); err != nil {
+					return 0, false
+				}
+			}
+			other = parsed
+		default:
+			return 0, false
+		}
+		return t.Compare(other), true
+	}
+
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// number converts integer and floating-point values to float64
+func number(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
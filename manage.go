@@ -0,0 +1,78 @@
+package gojango
+
+import "fmt"
+
+// Manage runs a management command for the given models, like Django's
+// manage.py. Call it once routes are registered; it reports whether args
+// named a command, so main can fall through to Run otherwise:
+//
+//	if ran, err := app.Manage(os.Args[1:], &User{}, &Post{}); ran {
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		return
+//	}
+//	app.Run(":8000")
+//
+// Commands:
+//
+//	makemigrations    print the SQL migrate would run, without running it
+//	migrate           create missing tables and add missing columns
+//	runserver [addr]  run the server (the address defaults as in Run)
+//
+// The gojango command-line tool (cmd/gojango) runs these with "go run .".
+func (app *App) Manage(args []string, models ...interface{}) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "makemigrations":
+		return true, app.makeMigrations(models)
+	case "migrate":
+		if err := app.AutoMigrate(models...); err != nil {
+			return true, err
+		}
+		fmt.Printf("Migrated %d models\n", len(models))
+		return true, nil
+	case "runserver":
+		addr := ""
+		if len(args) > 1 {
+			addr = args[1]
+		}
+		return true, app.Run(addr)
+	}
+
+	return false, nil
+}
+
+// makeMigrations prints the statements each model's migration would run
+func (app *App) makeMigrations(models []interface{}) error {
+	if app.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	changes := 0
+	for _, model := range models {
+		statements, err := app.db.PlanMigration(model)
+		if err != nil {
+			return fmt.Errorf("failed to plan migration for %T: %v", model, err)
+		}
+		if len(statements) == 0 {
+			continue
+		}
+
+		fmt.Printf("-- %s\n", app.db.GetTableName(model))
+		for _, statement := range statements {
+			fmt.Printf("%s;\n", statement)
+		}
+		changes += len(statements)
+	}
+
+	if changes == 0 {
+		fmt.Println("No changes detected")
+	} else {
+		fmt.Printf("%d statements pending; run migrate to apply them\n", changes)
+	}
+	return nil
+}
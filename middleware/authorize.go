@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+)
+
+// UserKey is the context key RequireRole and RequirePermission read the
+// authenticated user from; authentication middleware (such as contrib/auth's
+// RequireAuth) stores the user there
+const UserKey = "user"
+
+// Authorizer is implemented by user models that can be checked for roles
+// and permissions
+type Authorizer interface {
+	HasRole(role string) bool
+	HasPermission(permission string) bool
+}
+
+// RequireRole middleware lets the request through only when the
+// authenticated user has role. Place it after the authentication middleware:
+// without a user the answer is 401, and a user without the role gets 403.
+func RequireRole(role string) func(Context) error {
+	return authorize(func(user Authorizer) bool {
+		return user.HasRole(role)
+	}, fmt.Sprintf("requires role %q", role))
+}
+
+// RequirePermission middleware is RequireRole for a permission such as
+// "posts.delete"
+func RequirePermission(permission string) func(Context) error {
+	return authorize(func(user Authorizer) bool {
+		return user.HasPermission(permission)
+	}, fmt.Sprintf("requires permission %q", permission))
+}
+
+// authorize answers 401 or 403 unless allowed accepts the context's user
+func authorize(allowed func(Authorizer) bool, requirement string) func(Context) error {
+	return func(c Context) error {
+		value, ok := c.Get(UserKey)
+		if !ok || value == nil {
+			c.Abort()
+			return c.ErrorJSON(401, "Unauthorized", nil)
+		}
+
+		user, ok := value.(Authorizer)
+		if !ok || !allowed(user) {
+			c.Abort()
+			return c.ErrorJSON(403, "Forbidden", errors.New(requirement))
+		}
+		return nil
+	}
+}
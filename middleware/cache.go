@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cachedResponse is what CacheResponse and Idempotency store for a response
+type cachedResponse struct {
+	// Status is only set by Idempotency; CacheResponse stores 200s
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// RequestHash is the SHA-256 of the request body, set by Idempotency
+	RequestHash string `json:"request_hash,omitempty"`
+}
+
+// CacheResponse middleware serves GET requests from the app's cache for ttl,
+// keyed by path and query string plus the request headers named by the
+// response's Vary header. Only 200 responses are stored, and never ones for
+// requests with an Authorization or Cookie header (which may identify the
+// user), responses that set a cookie, say Vary: * or Cache-Control: private
+// or no-store, or responses the handler flushed or hijacked. The X-Cache
+// response header says whether the cache answered (HIT) or the handler ran
+// (MISS).
+func CacheResponse(ttl time.Duration) func(Context) error {
+	return func(c Context) error {
+		store := c.Cache()
+		if store == nil || c.Method() != http.MethodGet ||
+			c.GetHeader("Authorization") != "" || c.GetHeader("Cookie") != "" {
+			return nil
+		}
+
+		// The Vary header names stored for this URI select the variant
+		varyKey := "response-vary:" + c.RequestURI()
+		var vary []string
+		if data, ok, err := store.Get(varyKey); err == nil && ok {
+			vary = strings.Split(string(data), ",")
+		}
+
+		key := responseKey(c, vary)
+		if data, ok, err := store.Get(key); err != nil {
+			log.Printf("Response cache read failed: %v", err)
+		} else if ok {
+			var cached cachedResponse
+			if err := json.Unmarshal(data, &cached); err == nil {
+				header := c.Writer().Header()
+				for name, values := range cached.Header {
+					header[name] = values
+				}
+				header.Set("X-Cache", "HIT")
+				c.Writer().WriteHeader(http.StatusOK)
+				c.Writer().Write(cached.Body)
+				c.Abort()
+				return nil
+			}
+		}
+
+		original := c.Writer()
+		buffer := &bufferedWriter{ResponseWriter: original}
+		c.SetWriter(buffer)
+
+		original.Header().Set("X-Cache", "MISS")
+		err := c.Next()
+		c.SetWriter(original)
+		if err != nil || buffer.passthrough {
+			// A flushed or hijacked response has already gone to the client
+			return err
+		}
+
+		status := buffer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status == http.StatusOK && cacheable(original.Header()) {
+			header := original.Header().Clone()
+			header.Del("X-Cache")
+
+			vary = varyHeaders(header)
+			if err := store.Set(varyKey, []byte(strings.Join(vary, ",")), ttl); err != nil {
+				log.Printf("Response cache write failed: %v", err)
+			}
+			key = responseKey(c, vary)
+
+			if data, err := json.Marshal(cachedResponse{Header: header, Body: buffer.body.Bytes()}); err == nil {
+				if err := store.Set(key, data, ttl); err != nil {
+					log.Printf("Response cache write failed: %v", err)
+				}
+			}
+		}
+
+		original.WriteHeader(status)
+		_, err = original.Write(buffer.body.Bytes())
+		return err
+	}
+}
+
+// responseKey is the cache key of the request's variant, given the header
+// names its response varies on
+func responseKey(c Context, vary []string) string {
+	key := "response:" + c.RequestURI()
+	for _, name := range vary {
+		if name != "" {
+			key += "\n" + name + ":" + c.GetHeader(name)
+		}
+	}
+	return key
+}
+
+// varyHeaders returns the canonical, sorted request header names a response
+// varies on
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cacheable reports whether response headers allow sharing the response
+func cacheable(header http.Header) bool {
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" {
+			return false
+		}
+	}
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "private", "no-store":
+			return false
+		}
+	}
+	return true
+}
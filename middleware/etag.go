@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ETag middleware adds an ETag to successful GET and HEAD responses and
+// answers 304 Not Modified when it matches the client's If-None-Match.
+// The response is buffered to compute the hash; a handler can set its own
+// ETag header to skip hashing. Responses the handler flushes are streamed
+// without an ETag, and upgrade requests (WebSockets) aren't buffered.
+func ETag() func(Context) error {
+	return func(c Context) error {
+		if c.Method() != http.MethodGet && c.Method() != http.MethodHead {
+			return nil
+		}
+		if c.GetHeader("Upgrade") != "" {
+			return nil
+		}
+
+		original := c.Writer()
+		buffer := &bufferedWriter{ResponseWriter: original}
+		c.SetWriter(buffer)
+
+		err := c.Next()
+		c.SetWriter(original)
+		if err != nil || buffer.passthrough {
+			return err
+		}
+
+		status := buffer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status != http.StatusOK {
+			original.WriteHeader(status)
+			_, err := original.Write(buffer.body.Bytes())
+			return err
+		}
+
+		etag := original.Header().Get("ETag")
+		if etag == "" {
+			sum := sha1.Sum(buffer.body.Bytes())
+			etag = `"` + hex.EncodeToString(sum[:]) + `"`
+			original.Header().Set("ETag", etag)
+		}
+
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			original.Header().Del("Content-Type")
+			original.Header().Del("Content-Length")
+			original.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		original.WriteHeader(status)
+		_, err = original.Write(buffer.body.Bytes())
+		return err
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds the status and body so they can be inspected before
+// being sent. Headers go straight to the underlying writer's header map.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	// written is true once the body has started, fixing the status
+	written bool
+	// passthrough is true once Flush or Hijack handed the response to the
+	// underlying writer
+	passthrough bool
+}
+
+// WriteHeader records the status code; the last call before the body wins,
+// as with the framework's writer
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !w.written {
+		w.status = code
+	}
+}
+
+// Write appends to the buffer
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	w.written = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// Flush sends what was buffered and streams the rest of the response, which
+// then gets no ETag
+func (w *bufferedWriter) Flush() {
+	if !w.passthrough {
+		w.passthrough = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(w.body.Bytes())
+			w.body.Reset()
+		}
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack passes through to the underlying writer (for WebSockets)
+func (w *bufferedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.passthrough = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gojango/cache"
+)
+
+// IdempotencyHeader is the request header carrying the client's idempotency key
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyOption configures the Idempotency middleware
+type IdempotencyOption func(*idempotencyConfig)
+
+type idempotencyConfig struct {
+	identity func(Context) string
+}
+
+// WithIdentity scopes keys to the client fn returns (e.g. the authenticated
+// user's ID) instead of the Authorization and Cookie headers
+func WithIdentity(fn func(Context) string) IdempotencyOption {
+	return func(cfg *idempotencyConfig) {
+		cfg.identity = fn
+	}
+}
+
+// Idempotency middleware makes POST, PUT and PATCH requests that send an
+// Idempotency-Key header safe to retry: the first response for a key is
+// stored in store for ttl and replayed, with an Idempotent-Replayed: true
+// header, for later requests with the same key instead of running the
+// handler again. A nil store uses the app's cache.
+//
+// Keys are scoped to the method, path and client (the Authorization and
+// Cookie headers, or WithIdentity), so clients can't replay each other's
+// responses, and Set-Cookie isn't stored. Reusing a key with a different
+// body gets a 422. 5xx responses, handler errors and responses the handler
+// flushed or hijacked aren't stored, so the retry runs again. A duplicate
+// arriving while the first request is still running gets a 409.
+func Idempotency(store cache.Cache, ttl time.Duration, options ...IdempotencyOption) func(Context) error {
+	cfg := &idempotencyConfig{}
+	for _, opt := range options {
+		opt(cfg)
+	}
+	identity := cfg.identity
+	if identity == nil {
+		identity = func(c Context) string {
+			return c.GetHeader("Authorization") + "\n" + c.GetHeader("Cookie")
+		}
+	}
+
+	var mu sync.Mutex
+	inFlight := make(map[string]bool)
+
+	return func(c Context) error {
+		switch c.Method() {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return nil
+		}
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			return nil
+		}
+
+		responses := store
+		if responses == nil {
+			responses = c.Cache()
+		}
+		if responses == nil {
+			return nil
+		}
+
+		scope := sha256.Sum256([]byte(c.Method() + " " + c.Path() + "\n" + identity(c) + "\n" + key))
+		cacheKey := "idempotency:" + hex.EncodeToString(scope[:])
+
+		body, err := c.Body()
+		if err != nil {
+			c.Abort()
+			return c.ErrorJSON(http.StatusBadRequest, "Invalid body", err)
+		}
+		sum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(sum[:])
+
+		if replayed, err := replayResponse(c, responses, cacheKey, bodyHash); err != nil || replayed {
+			return err
+		}
+
+		mu.Lock()
+		if inFlight[cacheKey] {
+			mu.Unlock()
+			c.Abort()
+			return c.ErrorJSON(http.StatusConflict, "Conflict", errRequestInProgress)
+		}
+		inFlight[cacheKey] = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			delete(inFlight, cacheKey)
+			mu.Unlock()
+		}()
+
+		// The first request may have finished between the lookup and the lock
+		if replayed, err := replayResponse(c, responses, cacheKey, bodyHash); err != nil || replayed {
+			return err
+		}
+
+		original := c.Writer()
+		buffer := &bufferedWriter{ResponseWriter: original}
+		c.SetWriter(buffer)
+
+		err = c.Next()
+		c.SetWriter(original)
+		if err != nil || buffer.passthrough {
+			// A flushed or hijacked response has already gone to the client
+			return err
+		}
+
+		status := buffer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status < http.StatusInternalServerError {
+			// A replayed session cookie would log the retrying client in as
+			// whoever made the first request
+			header := original.Header().Clone()
+			header.Del("Set-Cookie")
+			cached := cachedResponse{Status: status, Header: header, Body: buffer.body.Bytes(), RequestHash: bodyHash}
+			if data, err := json.Marshal(cached); err == nil {
+				if err := responses.Set(cacheKey, data, ttl); err != nil {
+					log.Printf("Idempotency store write failed: %v", err)
+				}
+			}
+		}
+
+		original.WriteHeader(status)
+		_, err = original.Write(buffer.body.Bytes())
+		return err
+	}
+}
+
+// errRequestInProgress is the detail of the 409 for concurrent duplicates
+var errRequestInProgress = errors.New("a request with this Idempotency-Key is in progress")
+
+// errKeyReused is the detail of the 422 for a key sent with another body
+var errKeyReused = errors.New("this Idempotency-Key was used with a different request body")
+
+// replayResponse writes the stored response for key, if there is one, or a
+// 422 when it was stored for a request with another body hash
+func replayResponse(c Context, store cache.Cache, key, bodyHash string) (bool, error) {
+	data, ok, err := store.Get(key)
+	if err != nil {
+		log.Printf("Idempotency store read failed: %v", err)
+		return false, nil
+	}
+	if !ok {
+		return false, nil
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return false, nil
+	}
+	if cached.RequestHash != bodyHash {
+		c.Abort()
+		return true, c.ErrorJSON(http.StatusUnprocessableEntity, "Unprocessable Entity", errKeyReused)
+	}
+
+	header := c.Writer().Header()
+	for name, values := range cached.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Writer().WriteHeader(cached.Status)
+	c.Abort()
+	_, err = c.Writer().Write(cached.Body)
+	return true, err
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ClaimsKey is the context key JWT stores the verified Claims under
+const ClaimsKey = "jwt_claims"
+
+var (
+	// ErrInvalidToken is returned for malformed tokens and bad signatures
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for tokens past their exp claim
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims is the payload of a JSON Web Token
+type Claims map[string]interface{}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// jwtHeader is the only header SignJWT produces and ParseJWT accepts
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// SignJWT returns an HS256 JSON Web Token for claims. Set "exp" (Unix
+// seconds) to make it expire:
+//
+//	token, err := middleware.SignJWT(middleware.Claims{
+//		"sub": "42",
+//		"exp": time.Now().Add(24 * time.Hour).Unix(),
+//	}, secret)
+func SignJWT(claims Claims, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claims: %v", err)
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned, secret), nil
+}
+
+// ParseJWT verifies an HS256 token signed with secret and returns its
+// claims. Tokens whose exp has passed or whose nbf hasn't been reached are
+// rejected.
+func ParseJWT(token string, secret []byte) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var fields struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &fields); err != nil || fields.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	expected := jwtSignature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// jwtSignature returns the base64url HMAC-SHA256 of the signing input
+func jwtSignature(unsigned string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// BearerToken returns the token from an "Authorization: Bearer <token>" header
+func BearerToken(c Context) string {
+	auth := c.GetHeader("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// JWT middleware requires a valid HS256 bearer token and stores its Claims
+// in the context under ClaimsKey. Missing, invalid and expired tokens get
+// a 401.
+func JWT(secret []byte) func(Context) error {
+	return func(c Context) error {
+		token := BearerToken(c)
+		if token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.Abort()
+			return c.ErrorJSON(401, "Unauthorized", nil)
+		}
+
+		claims, err := ParseJWT(token, secret)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.Abort()
+			return c.ErrorJSON(401, "Unauthorized", err)
+		}
+
+		c.Set(ClaimsKey, claims)
+		return nil
+	}
+}
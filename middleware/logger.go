@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Logger middleware logs one line per request with the method, path,
+// status, response size, duration and client IP. By default lines go to the
+// standard logger in key=value form; pass WithJSONFormat for JSON lines or
+// WithLogFunc to handle the entries yourself.
+func Logger(options ...LoggerOption) func(Context) error {
+	cfg := &loggerConfig{}
+	for _, opt := range options {
+		opt(cfg)
+	}
+
+	logFunc := cfg.logFunc
+	if logFunc == nil {
+		logFunc = func(entry LogEntry) {
+			if cfg.json {
+				log.Print(entry.JSON())
+			} else {
+				log.Print(entry.String())
+			}
+		}
+	}
+
+	return func(c Context) error {
+		start := time.Now()
+
+		original := c.Writer()
+		recorder := &statusRecorder{ResponseWriter: original}
+		c.SetWriter(recorder)
+
+		// Restore the writer and log even if a handler panics, so the
+		// request shows up with a 500 while the panic carries on to Recovery
+		var err error
+		returned := false
+		defer func() {
+			c.SetWriter(original)
+
+			status := recorder.status
+			if (err != nil || !returned) && status == 0 {
+				// The framework answers unhandled errors with a 500
+				status = http.StatusInternalServerError
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			logFunc(LogEntry{
+				Time:      start,
+				Method:    c.Method(),
+				Path:      c.Path(),
+				Status:    status,
+				Size:      recorder.size,
+				Duration:  time.Since(start),
+				ClientIP:  c.ClientIP(),
+				RequestID: requestID(c),
+			})
+		}()
+
+		err = c.Next()
+		returned = true
+		return err
+	}
+}
+
+// LogEntry describes a completed request, as recorded by Logger
+type LogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Size      int           `json:"size"`
+	Duration  time.Duration `json:"duration"`
+	ClientIP  string        `json:"client_ip"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// requestID returns the ID the RequestID middleware stored, or ""
+func requestID(c Context) string {
+	id, _ := c.Get(RequestIDKey)
+	s, _ := id.(string)
+	return s
+}
+
+// String formats the entry as key=value pairs
+func (e LogEntry) String() string {
+	line := fmt.Sprintf("method=%s path=%q status=%d size=%d duration=%s ip=%s",
+		e.Method, e.Path, e.Status, e.Size, e.Duration, e.ClientIP)
+	if e.RequestID != "" {
+		line += " request_id=" + e.RequestID
+	}
+	return line
+}
+
+// JSON formats the entry as a JSON object, with the duration in milliseconds
+func (e LogEntry) JSON() string {
+	fields := map[string]interface{}{
+		"time":        e.Time.Format(time.RFC3339),
+		"method":      e.Method,
+		"path":        e.Path,
+		"status":      e.Status,
+		"size":        e.Size,
+		"duration_ms": float64(e.Duration.Microseconds()) / 1000,
+		"client_ip":   e.ClientIP,
+	}
+	if e.RequestID != "" {
+		fields["request_id"] = e.RequestID
+	}
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// LoggerOption configures the Logger middleware
+type LoggerOption func(*loggerConfig)
+
+type loggerConfig struct {
+	json    bool
+	logFunc func(LogEntry)
+}
+
+// WithJSONFormat writes each entry as a JSON line instead of key=value pairs
+func WithJSONFormat() LoggerOption {
+	return func(cfg *loggerConfig) {
+		cfg.json = true
+	}
+}
+
+// WithLogFunc sends each entry to fn instead of the standard logger
+func WithLogFunc(fn func(LogEntry)) LoggerOption {
+	return func(cfg *loggerConfig) {
+		cfg.logFunc = fn
+	}
+}
+
+// statusRecorder passes writes through while recording the status and size
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+	// sent is true once the body has started, fixing the status
+	sent bool
+}
+
+// WriteHeader records the status code. Like the framework's writer, which
+// sends the status with the first body write, the last call before the body
+// wins.
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.sent {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the number of bytes written
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.send()
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// send fixes the status once the response starts, 200 if none was set
+func (w *statusRecorder) send() {
+	w.sent = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+}
+
+// Flush passes through to the underlying writer
+func (w *statusRecorder) Flush() {
+	w.send()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer (for WebSockets)
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
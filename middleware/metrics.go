@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsContentType is the content type of the Prometheus text format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the request duration histogram buckets, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMetricsRegistry collects the metrics recorded by Metrics
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+// Metrics middleware records request counts, durations and in-flight
+// requests in DefaultMetricsRegistry. Requests are labeled by method, route
+// pattern (e.g. /users/:id, keeping label cardinality bounded) and status.
+func Metrics() func(Context) error {
+	return DefaultMetricsRegistry.Middleware()
+}
+
+// metricLabels identifies a request series
+type metricLabels struct {
+	method string
+	path   string
+	status int
+}
+
+// histogram is a cumulative duration histogram for one series
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// MetricsRegistry holds request metrics
+type MetricsRegistry struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[metricLabels]uint64
+	durations map[metricLabels]*histogram
+	inFlight  int64
+}
+
+// NewMetricsRegistry creates an empty registry using DefaultBuckets
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		buckets:   DefaultBuckets,
+		requests:  make(map[metricLabels]uint64),
+		durations: make(map[metricLabels]*histogram),
+	}
+}
+
+// Middleware returns middleware recording requests in this registry
+func (m *MetricsRegistry) Middleware() func(Context) error {
+	return func(c Context) error {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		start := time.Now()
+
+		original := c.Writer()
+		recorder := &statusRecorder{ResponseWriter: original}
+		c.SetWriter(recorder)
+
+		// Undo both even if a handler panics
+		defer func() {
+			c.SetWriter(original)
+			m.mu.Lock()
+			m.inFlight--
+			m.mu.Unlock()
+		}()
+
+		err := c.Next()
+
+		status := recorder.status
+		if err != nil && status == 0 {
+			status = http.StatusInternalServerError
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		path := c.RoutePattern()
+		if path == "" {
+			path = "unmatched"
+		}
+
+		m.observe(metricLabels{method: c.Method(), path: path, status: status}, time.Since(start))
+		return err
+	}
+}
+
+// observe records a finished request
+func (m *MetricsRegistry) observe(labels metricLabels, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[labels]++
+
+	h, exists := m.durations[labels]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[labels] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Write writes the metrics in the Prometheus text exposition format
+func (m *MetricsRegistry) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series := make([]metricLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		series = append(series, labels)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	var sb strings.Builder
+
+	sb.WriteString("# HELP http_requests_total Total number of HTTP requests.\n")
+	sb.WriteString("# TYPE http_requests_total counter\n")
+	for _, labels := range series {
+		fmt.Fprintf(&sb, "http_requests_total{%s} %d\n", labels.format(), m.requests[labels])
+	}
+
+	sb.WriteString("# HELP http_request_duration_seconds HTTP request duration in seconds.\n")
+	sb.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, labels := range series {
+		h := m.durations[labels]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(&sb, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels.format(), strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(&sb, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels.format(), h.count)
+		fmt.Fprintf(&sb, "http_request_duration_seconds_sum{%s} %s\n", labels.format(), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&sb, "http_request_duration_seconds_count{%s} %d\n", labels.format(), h.count)
+	}
+
+	sb.WriteString("# HELP http_requests_in_flight Number of HTTP requests being served.\n")
+	sb.WriteString("# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(&sb, "http_requests_in_flight %d\n", m.inFlight)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Handler serves the metrics over HTTP
+func (m *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		m.Write(w)
+	})
+}
+
+// format renders the labels as Prometheus label pairs
+func (l metricLabels) format() string {
+	return fmt.Sprintf(`method="%s",path="%s",status="%d"`, escapeLabel(l.method), escapeLabel(l.path), l.status)
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -1,38 +1,43 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
+
+	"gojango/cache"
 )
 
 // Context interface for middleware compatibility
 type Context interface {
 	Method() string
 	Path() string
+	// RequestURI is the path and query string
+	RequestURI() string
+	RoutePattern() string
 	ClientIP() string
 	GetHeader(string) string
 	Header(string, string)
+	// Body reads the request body, leaving it readable for the handler
+	Body() ([]byte, error)
 	ErrorJSON(int, string, error) error
-}
 
-// Logger middleware logs HTTP requests
-func Logger() func(Context) error {
-	return func(c Context) error {
-		start := time.Now()
-		
-		// Log the request
-		log.Printf("%s %s from %s", c.Method(), c.Path(), c.ClientIP())
-		
-		// You would normally call the next handler here,
-		// but since our middleware system is simple, we just return
-		// The actual request handling happens in the main handler chain
-		
-		duration := time.Since(start)
-		log.Printf("Request completed in %v", duration)
-		
-		return nil
-	}
+	// Next runs the rest of the chain, letting middleware act on the response
+	Next() error
+	// Abort stops the chain after the current middleware, e.g. once it has
+	// rejected the request
+	Abort()
+	Writer() http.ResponseWriter
+	SetWriter(http.ResponseWriter)
+	// Cache returns the app's cache
+	Cache() cache.Cache
+	// Set stores a value for later middleware and the handler; Get reads it
+	Set(string, interface{})
+	Get(string) (interface{}, bool)
 }
 
 // CORS middleware adds CORS headers
@@ -43,7 +48,7 @@ func CORS(allowOrigin string) func(Context) error {
 	
 	return func(c Context) error {
 		c.Header("Access-Control-Allow-Origin", allowOrigin)
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		c.Header("Access-Control-Max-Age", "3600")
 		
@@ -76,12 +81,14 @@ func BasicAuth(username, password string) func(Context) error {
 		auth := c.GetHeader("Authorization")
 		if auth == "" {
 			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
+			c.Abort()
 			return c.ErrorJSON(401, "Unauthorized", nil)
 		}
 		
-		// Simple basic auth check (in production, use proper crypto)
-		expectedAuth := fmt.Sprintf("Basic %s", basicAuthEncode(username+":"+password))
-		if auth != expectedAuth {
+		expectedAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(expectedAuth)) != 1 {
+			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
+			c.Abort()
 			return c.ErrorJSON(401, "Unauthorized", nil)
 		}
 		
@@ -89,25 +96,53 @@ func BasicAuth(username, password string) func(Context) error {
 	}
 }
 
-// Helper function for basic auth encoding (simplified)
-func basicAuthEncode(credentials string) string {
-	// In a real implementation, you'd use base64 encoding
-	// This is just a placeholder
-	return credentials
-}
+// RequestIDKey is the context key RequestID stores the request's ID under
+const RequestIDKey = "request_id"
+
+// RequestIDHeader carries the request ID to and from proxies and clients
+const RequestIDHeader = "X-Request-ID"
 
-// RequestID middleware adds a unique request ID
+// RequestID middleware gives each request an ID, stored in the context under
+// RequestIDKey (read it with Context.RequestID) and echoed in the
+// X-Request-ID response header. An X-Request-ID sent by an upstream proxy is
+// reused so one ID follows the request across services; otherwise a random
+// UUID is generated. Logger includes the ID in its entries.
 func RequestID() func(Context) error {
 	return func(c Context) error {
-		requestID := generateRequestID()
-		c.Header("X-Request-ID", requestID)
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = generateRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
 		return nil
 	}
 }
 
-// generateRequestID generates a simple request ID
+// validRequestID accepts incoming IDs of at most 128 printable ASCII
+// characters, so a client can't inject arbitrary text into headers and logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRequestID returns a random (version 4) UUID
 func generateRequestID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand doesn't fail on supported platforms; stay unique anyway
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
 
 // RateLimit middleware provides simple rate limiting
@@ -129,6 +164,7 @@ func RateLimit(maxRequests int, window time.Duration) func(Context) error {
 		requestCounts[clientIP]++
 		
 		if requestCounts[clientIP] > maxRequests {
+			c.Abort()
 			return c.ErrorJSON(429, "Too Many Requests", nil)
 		}
 		
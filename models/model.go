@@ -28,6 +28,16 @@ func (m *Model) TableName() string {
 	return ""
 }
 
+// Models may also define any of these hooks, which DB.Create, DB.Update and
+// DB.Delete call around the write (QuerySet bulk updates and deletes don't):
+//
+//	AfterCreate()   after the row is inserted and its ID set
+//	AfterUpdate()   after the row is updated
+//	BeforeDelete()  before the row is deleted
+//	AfterDelete()   after the row is deleted
+//
+// After hooks only run when the write succeeds.
+
 // ModelInterface defines the interface that all models should implement
 type ModelInterface interface {
 	TableName() string
@@ -44,9 +54,12 @@ const (
 	TagNotNull     = "not_null"
 	TagUnique      = "unique"
 	TagIndex       = "index"
+	TagUniqueIndex = "unique_index"
 	TagDefault     = "default"
 	TagSize        = "size"
 	TagType        = "type"
+	TagChoices     = "choices"
+	TagHashed      = "hashed"
 )
 
 // Common field types
@@ -58,6 +71,7 @@ const (
 	TypeDatetime  = "DATETIME"
 	TypeBoolean   = "BOOLEAN"
 	TypeVarchar   = "VARCHAR"
+	TypeJSON      = "JSON"
 )
 
 // ValidationError represents a model validation error
@@ -75,6 +89,20 @@ type Validator interface {
 	Validate() []ValidationError
 }
 
+// Views a Serializer is asked for by the CRUD endpoints
+const (
+	// ViewList is each item of a list response
+	ViewList = "list"
+	// ViewDetail is a single record: retrieve, create and update responses
+	ViewDetail = "detail"
+)
+
+// Serializer lets a model choose its JSON representation per view, e.g. to
+// hide fields in lists that a detail response shows
+type Serializer interface {
+	Serialize(view string) interface{}
+}
+
 // Example model structure that users can follow:
 /*
 type User struct {
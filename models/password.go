@@ -0,0 +1,94 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordCost is the bcrypt cost HashPassword uses (default 10, allowed
+// 4-31). Each step doubles the time a hash takes, and with it the cost of
+// guessing; raise it as hardware gets faster. Existing hashes keep working
+// because each hash records its own cost.
+var PasswordCost = bcrypt.DefaultCost
+
+// HashPassword returns the bcrypt hash of a plaintext password
+func HashPassword(plain string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), PasswordCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether plain is the password hash was made from
+func CheckPassword(hash, plain string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil
+}
+
+// IsHashed reports whether value is already a bcrypt hash
+func IsHashed(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+// HashPasswords replaces the plaintext in every string field tagged with
+// the hashed db option by its bcrypt hash, leaving empty values and values
+// that are already hashes alone. DB.Create, DB.Update and DB.Upsert call it
+// after the BeforeCreate/BeforeUpdate hooks:
+//
+//	Password string `json:"-" db:"password,not_null,size:255,hashed"`
+func HashPasswords(model interface{}) error {
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return hashFields(value.Elem())
+}
+
+// hashFields hashes the hashed fields of a struct, including embedded ones
+func hashFields(value reflect.Value) error {
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			if err := hashFields(fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !field.IsExported() || fieldValue.Kind() != reflect.String || !hasDBOption(field, "hashed") {
+			continue
+		}
+
+		plain := fieldValue.String()
+		if plain == "" || IsHashed(plain) {
+			continue
+		}
+
+		hash, err := HashPassword(plain)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field.Name, err)
+		}
+		fieldValue.SetString(hash)
+	}
+	return nil
+}
+
+// hasDBOption reports whether the field's db tag lists option after the column name
+func hasDBOption(field reflect.StructField, option string) bool {
+	parts := strings.Split(field.Tag.Get(TagDB), ",")
+	for _, part := range parts[1:] {
+		if strings.TrimSpace(part) == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ValidationErrors collects the errors found while validating a model
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks the rules declared in struct tags and then calls the model's
+// own Validate method if it implements Validator.
+//
+// Supported tags:
+//
+//	Status string `json:"status" db:"status" choices:"draft|published|archived"`
+func Validate(model interface{}) ValidationErrors {
+	var errors ValidationErrors
+
+	value := reflect.ValueOf(model)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() == reflect.Struct {
+		errors = append(errors, validateFields(value)...)
+	}
+
+	if validator, ok := model.(Validator); ok {
+		errors = append(errors, validator.Validate()...)
+	}
+
+	return errors
+}
+
+// validateFields applies tag-based rules to every field, including those of
+// embedded structs
+func validateFields(value reflect.Value) ValidationErrors {
+	var errors ValidationErrors
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			errors = append(errors, validateFields(fieldValue)...)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if choices := Choices(field); len(choices) > 0 {
+			current := reflect.Indirect(fieldValue)
+			if !current.IsValid() || current.Kind() != reflect.String {
+				continue
+			}
+
+			if !containsString(choices, current.String()) {
+				errors = append(errors, ValidationError{
+					Field:   fieldName(field),
+					Message: "must be one of: " + strings.Join(choices, ", "),
+				})
+			}
+		}
+	}
+
+	return errors
+}
+
+// Choices returns the allowed values declared in a field's choices tag
+func Choices(field reflect.StructField) []string {
+	tag := field.Tag.Get(TagChoices)
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, "|")
+}
+
+// fieldName returns the name clients know a field by: its JSON name, then its
+// column name, then the Go name
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{TagJSON, TagDB} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,427 @@
+package gojango
+
+import (
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gojango/models"
+	"gojango/router"
+)
+
+// crudResource records a model exposed by RegisterCRUD so OpenAPI can
+// describe its endpoints
+type crudResource struct {
+	basePath  string
+	modelType reflect.Type
+	options   *crudOptions
+}
+
+// OpenAPI returns an OpenAPI 3 document describing every registered route.
+// Endpoints created by RegisterCRUD get request and response schemas derived
+// from the model's json and db tags; other routes are listed with their path
+// parameters only. The title and version come from the "app.name" and
+// "app.version" settings.
+func (app *App) OpenAPI() map[string]interface{} {
+	spec := &openAPISpec{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+
+	for _, route := range app.Routes() {
+		path := openAPIPath(route.Pattern)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = spec.operation(app.crudResourceFor(route), route)
+	}
+
+	spec.schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error":   map[string]interface{}{"type": "string"},
+			"status":  map[string]interface{}{"type": "integer"},
+			"details": map[string]interface{}{"type": "string"},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   app.config.GetString("app.name", "GoJango API"),
+			"version": app.config.GetString("app.version", "1.0.0"),
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": spec.schemas},
+	}
+}
+
+// OpenAPIHandler serves the document built by OpenAPI as JSON:
+//
+//	app.GET("/openapi.json", app.OpenAPIHandler())
+func (app *App) OpenAPIHandler() HandlerFunc {
+	return func(c *Context) error {
+		return c.JSON(app.OpenAPI())
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the spec
+var swaggerUIPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`))
+
+// SwaggerUIHandler serves a Swagger UI page for the document at specURL:
+//
+//	app.GET("/openapi.json", app.OpenAPIHandler())
+//	app.GET("/docs", app.SwaggerUIHandler("/openapi.json"))
+func (app *App) SwaggerUIHandler(specURL string) HandlerFunc {
+	return func(c *Context) error {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		return swaggerUIPage.Execute(c.Response, map[string]string{
+			"Title":   app.config.GetString("app.name", "GoJango API"),
+			"SpecURL": specURL,
+		})
+	}
+}
+
+// crudResourceFor returns the RegisterCRUD model behind a route, if any
+func (app *App) crudResourceFor(route router.RouteInfo) *crudResource {
+	for i := range app.crudResources {
+		resource := &app.crudResources[i]
+		if route.Pattern == resource.basePath || route.Pattern == resource.basePath+"/:id" {
+			return resource
+		}
+	}
+	return nil
+}
+
+// openAPIPath converts :param segments to OpenAPI's {param} form
+func openAPIPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// openAPISpec collects the component schemas while operations are built
+type openAPISpec struct {
+	schemas map[string]interface{}
+}
+
+// operation describes one route, in detail when a CRUD model backs it
+func (s *openAPISpec) operation(resource *crudResource, route router.RouteInfo) map[string]interface{} {
+	op := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "OK"},
+		},
+	}
+
+	if len(route.Params) > 0 {
+		params := make([]interface{}, len(route.Params))
+		for i, name := range route.Params {
+			params[i] = map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			}
+		}
+		op["parameters"] = params
+	}
+
+	if resource == nil {
+		return op
+	}
+
+	name := resource.modelType.Name()
+	detail := route.Pattern != resource.basePath
+	responses := make(map[string]interface{})
+	op["tags"] = []string{name}
+	op["responses"] = responses
+
+	switch {
+	case route.Method == "GET" && !detail:
+		op["summary"] = "List " + name + " records"
+		responses["200"] = jsonResponse("OK", map[string]interface{}{
+			"type":  "array",
+			"items": s.modelSchema(resource, models.ViewList),
+		})
+	case route.Method == "POST" && !detail:
+		op["summary"] = "Create a " + name
+		op["requestBody"] = s.requestBody(resource)
+		created := jsonResponse("Created", s.modelSchema(resource, models.ViewDetail))
+		created["headers"] = map[string]interface{}{
+			"Location": map[string]interface{}{
+				"description": "URL of the new record",
+				"schema":      map[string]interface{}{"type": "string"},
+			},
+		}
+		responses["201"] = created
+		responses["400"] = errorResponse("Invalid input")
+	case route.Method == "GET":
+		op["summary"] = "Get a " + name
+		responses["200"] = jsonResponse("OK", s.modelSchema(resource, models.ViewDetail))
+		responses["404"] = errorResponse("Not found")
+	case route.Method == "PUT" || route.Method == "PATCH":
+		op["summary"] = "Update fields of a " + name
+		op["requestBody"] = s.requestBody(resource)
+		responses["200"] = jsonResponse("Updated", s.modelSchema(resource, models.ViewDetail))
+		responses["400"] = errorResponse("Invalid input")
+		responses["404"] = errorResponse("Not found")
+	case route.Method == "DELETE" && !detail:
+		op["summary"] = "Delete " + name + " records by id"
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"ids"},
+					"properties": map[string]interface{}{
+						"ids": map[string]interface{}{"type": "array", "minItems": 1, "items": map[string]interface{}{}},
+					},
+				}},
+			},
+		}
+		responses["200"] = jsonResponse("Deleted", map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"deleted": map[string]interface{}{"type": "integer"},
+			},
+		})
+		responses["400"] = errorResponse("No ids given")
+	case route.Method == "DELETE":
+		op["summary"] = "Delete a " + name
+		responses["200"] = jsonResponse("Deleted", map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"message": map[string]interface{}{"type": "string"},
+			},
+		})
+		responses["404"] = errorResponse("Not found")
+	case route.Method == "OPTIONS":
+		op["summary"] = "CORS preflight"
+		responses["204"] = map[string]interface{}{
+			"description": "Allowed methods",
+			"headers": map[string]interface{}{
+				"Allow": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+		}
+		return op
+	default:
+		responses["200"] = map[string]interface{}{"description": "OK"}
+	}
+	responses["500"] = errorResponse("Server error")
+
+	return op
+}
+
+// requestBody describes a JSON body holding the model
+func (s *openAPISpec) requestBody(resource *crudResource) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": s.ref(resource.modelType.Name(), resource, nil)},
+		},
+	}
+}
+
+// modelSchema returns a reference to the model's schema as view renders it.
+// Views narrowed with IncludeFields or ExcludeFields get their own component
+// (e.g. UserList); models with a custom Serializer are plain objects.
+func (s *openAPISpec) modelSchema(resource *crudResource, view string) map[string]interface{} {
+	if reflect.PtrTo(resource.modelType).Implements(reflect.TypeOf((*models.Serializer)(nil)).Elem()) {
+		return map[string]interface{}{"type": "object"}
+	}
+
+	include, exclude := resource.options.include[view], resource.options.exclude[view]
+	if len(include) == 0 && len(exclude) == 0 {
+		return s.ref(resource.modelType.Name(), resource, nil)
+	}
+
+	return s.ref(resource.modelType.Name()+strings.ToUpper(view[:1])+view[1:], resource, func(field string) bool {
+		if len(include) > 0 && !containsName(include, field) {
+			return false
+		}
+		return !containsName(exclude, field)
+	})
+}
+
+// ref adds the named component schema for the model on first use and
+// returns a reference to it; keep, when set, selects the properties
+func (s *openAPISpec) ref(name string, resource *crudResource, keep func(string) bool) map[string]interface{} {
+	if _, exists := s.schemas[name]; !exists {
+		schema := structSchema(resource.modelType)
+		if keep != nil {
+			properties := schema["properties"].(map[string]interface{})
+			for field := range properties {
+				if !keep(field) {
+					delete(properties, field)
+				}
+			}
+			if required, ok := schema["required"].([]string); ok {
+				var kept []string
+				for _, field := range required {
+					if keep(field) {
+						kept = append(kept, field)
+					}
+				}
+				if len(kept) > 0 {
+					schema["required"] = kept
+				} else {
+					delete(schema, "required")
+				}
+			}
+		}
+		s.schemas[name] = schema
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonResponse describes a JSON response with the given schema
+func jsonResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// errorResponse describes a response written by Context.ErrorJSON
+func errorResponse(description string) map[string]interface{} {
+	return jsonResponse(description, map[string]interface{}{"$ref": "#/components/schemas/Error"})
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structSchema describes the JSON encoding of a struct. The db tag adds
+// detail: primary keys are read-only, not_null columns without a default
+// are required, size limits strings and hashed fields are write-only.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	addStructFields(t, properties, &required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addStructFields adds the JSON fields of t, including those of embedded
+// structs, to properties
+func addStructFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if jsonName == "-" {
+			continue
+		}
+
+		if field.Anonymous && jsonName == "" && field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+
+		schema := typeSchema(field.Type)
+		options := strings.Split(field.Tag.Get(models.TagDB), ",")[1:]
+		hasDefault := false
+		for _, option := range options {
+			option = strings.TrimSpace(option)
+			switch {
+			case option == models.TagPrimaryKey:
+				schema["readOnly"] = true
+			case option == models.TagHashed:
+				schema["writeOnly"] = true
+			case strings.HasPrefix(option, models.TagDefault+":"):
+				hasDefault = true
+			case strings.HasPrefix(option, models.TagSize+":") && schema["type"] == "string":
+				if size, err := strconv.Atoi(strings.TrimPrefix(option, models.TagSize+":")); err == nil {
+					schema["maxLength"] = size
+				}
+			}
+		}
+		if containsName(options, models.TagNotNull) && !containsName(options, models.TagPrimaryKey) && !hasDefault {
+			*required = append(*required, jsonName)
+		}
+		if choices := models.Choices(field); len(choices) > 0 {
+			schema["enum"] = choices
+		}
+
+		properties[jsonName] = schema
+	}
+}
+
+// typeSchema maps a Go type to the schema of its JSON encoding
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+// containsName reports whether list contains name
+func containsName(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
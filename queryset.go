@@ -1,17 +1,28 @@
 package gojango
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"gojango/database"
 )
 
+// ErrDoesNotExist is returned by First and ScanOne when no row matches, like
+// Django's Model.DoesNotExist. It wraps database.ErrNotFound, so handlers
+// answering with a database error send a 404.
+var ErrDoesNotExist = fmt.Errorf("%w: no matching row", database.ErrNotFound)
+
 // QuerySet provides Django-like query capabilities
 type QuerySet struct {
+	ctx       context.Context
 	db        *database.DB
 	model     interface{}
 	modelType reflect.Type
@@ -21,115 +32,342 @@ type QuerySet struct {
 	orderBy   string
 	limit     int
 	offset    int
+	// lookups are the Filter and Exclude calls behind where, which the mock
+	// database evaluates in memory
+	lookups []lookup
+	// columns is the SELECT list set by Only or Defer; nil selects every column
+	columns []string
+	// annotations are extra SELECT expressions added by AnnotateWindow
+	annotations []string
+	// prefetch lists the many-to-many fields All loads with the results
+	prefetch []string
+
+	// err is the first invalid lookup passed to Filter or Exclude; it is
+	// returned by whichever method runs the query
+	err error
 }
 
 // NewQuerySet creates a new QuerySet for a model
 func NewQuerySet(db *database.DB, model interface{}) *QuerySet {
-	modelType := reflect.TypeOf(model)
-	if modelType.Kind() == reflect.Ptr {
-		modelType = modelType.Elem()
-	}
-
 	qs := &QuerySet{
 		db:        db,
 		model:     model,
-		modelType: modelType,
+		modelType: structType(model),
 		tableName: db.GetTableName(model),
 	}
 	return qs
 }
 
-// Filter adds WHERE conditions (Django-like)
-func (qs *QuerySet) Filter(field string, value interface{}) *QuerySet {
-	// Create a copy to avoid mutating the original
+// Clone returns an independent copy of qs, including its conditions,
+// arguments and column list. Every chainable method (Filter, OrderBy,
+// Limit...) starts from a clone, so a QuerySet is never modified after it is
+// created and can safely be branched:
+//
+//	active := qs.Filter("active", true)
+//	admins := active.Filter("role", "admin") // active is unchanged
+func (qs *QuerySet) Clone() *QuerySet {
 	newQS := *qs
-	newQS.where = make([]string, len(qs.where))
-	copy(newQS.where, qs.where)
-	newQS.args = make([]interface{}, len(qs.args))
-	copy(newQS.args, qs.args)
+	newQS.where = append([]string(nil), qs.where...)
+	newQS.args = append([]interface{}(nil), qs.args...)
+	newQS.lookups = append([]lookup(nil), qs.lookups...)
+	newQS.columns = append([]string(nil), qs.columns...)
+	newQS.annotations = append([]string(nil), qs.annotations...)
+	newQS.prefetch = append([]string(nil), qs.prefetch...)
+	return &newQS
+}
+
+// setErr records err unless an earlier error is already recorded
+func (qs *QuerySet) setErr(err error) {
+	if qs.err == nil {
+		qs.err = err
+	}
+}
+
+// WithContext returns a QuerySet whose queries are bound to ctx, so they are
+// aborted when it is cancelled (e.g. pass c.Request.Context() in a handler)
+func (qs *QuerySet) WithContext(ctx context.Context) *QuerySet {
+	newQS := qs.Clone()
+	newQS.ctx = ctx
+	return newQS
+}
+
+// context returns the QuerySet's context, defaulting to context.Background()
+func (qs *QuerySet) context() context.Context {
+	if qs.ctx == nil {
+		return context.Background()
+	}
+	return qs.ctx
+}
+
+// Filter adds WHERE conditions (Django-like). An unknown lookup doesn't
+// panic: the error is returned when the query runs (All, Count, Delete...).
+func (qs *QuerySet) Filter(field string, value interface{}) *QuerySet {
+	condition, args, err := parseLookup(qs.db.Driver(), field, value)
+	return qs.addWhere(lookup{field: field, value: value}, condition, args, err)
+}
+
+// FilterMap adds a condition for each entry of filters, like Django's
+// filter(active=True, age__gte=20):
+//
+//	qs.FilterMap(map[string]interface{}{"active": true, "age__gte": 20})
+//
+// Keys are applied in sorted order, so the same map always builds the same
+// SQL.
+func (qs *QuerySet) FilterMap(filters map[string]interface{}) *QuerySet {
+	fields := make([]string, 0, len(filters))
+	for field := range filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	newQS := qs.Clone()
+	for _, field := range fields {
+		newQS = newQS.Filter(field, filters[field])
+	}
+	return newQS
+}
+
+// Exclude adds WHERE NOT conditions. It accepts the same lookups as Filter
+// and negates the whole condition each one produces.
+func (qs *QuerySet) Exclude(field string, value interface{}) *QuerySet {
+	condition, args, err := parseLookup(qs.db.Driver(), field, value)
+	return qs.addWhere(lookup{field: field, value: value, exclude: true}, "NOT ("+condition+")", args, err)
+}
+
+// addWhere returns a copy of qs with one more condition, its arguments and
+// the lookup it was built from, or carrying err if the condition couldn't be
+// built
+func (qs *QuerySet) addWhere(l lookup, condition string, args []interface{}, err error) *QuerySet {
+	newQS := qs.Clone()
+	if err != nil {
+		newQS.setErr(err)
+		return newQS
+	}
+
+	newQS.where = append(newQS.where, condition)
+	newQS.args = append(newQS.args, args...)
+	newQS.lookups = append(newQS.lookups, l)
+	return newQS
+}
 
-	// Parse Django-style field lookups
+// parseLookup turns a Django-style "field__lookup" and its value into a SQL
+// condition for driver and the arguments for its placeholders. A field
+// without a lookup means exact. Unknown lookups and values of the wrong type
+// are errors.
+func parseLookup(driver, field string, value interface{}) (condition string, args []interface{}, err error) {
 	parts := strings.Split(field, "__")
 	fieldName := parts[0]
 	lookup := "exact"
 
-	if len(parts) > 1 {
+	if fieldName == "" || len(parts) > 2 {
+		return "", nil, fmt.Errorf("invalid filter %q", field)
+	}
+	if len(parts) == 2 {
 		lookup = parts[1]
 	}
 
-	var condition string
 	switch lookup {
 	case "exact":
-		condition = fieldName + " = ?"
+		return fieldName + " = ?", []interface{}{value}, nil
 	case "iexact":
-		condition = "LOWER(" + fieldName + ") = LOWER(?)"
+		return "LOWER(" + fieldName + ") = LOWER(?)", []interface{}{value}, nil
 	case "contains":
-		condition = fieldName + " LIKE ?"
-		value = "%" + fmt.Sprintf("%v", value) + "%"
+		return fieldName + " LIKE ?", []interface{}{"%" + fmt.Sprintf("%v", value) + "%"}, nil
 	case "icontains":
-		condition = "LOWER(" + fieldName + ") LIKE LOWER(?)"
-		value = "%" + fmt.Sprintf("%v", value) + "%"
+		return "LOWER(" + fieldName + ") LIKE LOWER(?)", []interface{}{"%" + fmt.Sprintf("%v", value) + "%"}, nil
 	case "startswith":
-		condition = fieldName + " LIKE ?"
-		value = fmt.Sprintf("%v", value) + "%"
+		return fieldName + " LIKE ?", []interface{}{fmt.Sprintf("%v", value) + "%"}, nil
 	case "endswith":
-		condition = fieldName + " LIKE ?"
-		value = "%" + fmt.Sprintf("%v", value)
+		return fieldName + " LIKE ?", []interface{}{"%" + fmt.Sprintf("%v", value)}, nil
 	case "gt":
-		condition = fieldName + " > ?"
+		return fieldName + " > ?", []interface{}{value}, nil
 	case "gte":
-		condition = fieldName + " >= ?"
+		return fieldName + " >= ?", []interface{}{value}, nil
 	case "lt":
-		condition = fieldName + " < ?"
+		return fieldName + " < ?", []interface{}{value}, nil
 	case "lte":
-		condition = fieldName + " <= ?"
+		return fieldName + " <= ?", []interface{}{value}, nil
 	case "in":
-		// Handle IN queries
-		if slice := reflect.ValueOf(value); slice.Kind() == reflect.Slice {
-			placeholders := make([]string, slice.Len())
-			for i := 0; i < slice.Len(); i++ {
-				placeholders[i] = "?"
-				newQS.args = append(newQS.args, slice.Index(i).Interface())
-			}
-			condition = fieldName + " IN (" + strings.Join(placeholders, ",") + ")"
-			// Don't add value to args since we already added individual items
-			goto skipValueAdd
+		slice := reflect.ValueOf(value)
+		if slice.Kind() != reflect.Slice && slice.Kind() != reflect.Array {
+			return "", nil, fmt.Errorf("%s expects a slice, got %T", field, value)
+		}
+		if slice.Len() == 0 {
+			// Nothing is in an empty list
+			return "1 = 0", nil, nil
+		}
+		placeholders := make([]string, slice.Len())
+		args := make([]interface{}, slice.Len())
+		for i := 0; i < slice.Len(); i++ {
+			placeholders[i] = "?"
+			args[i] = slice.Index(i).Interface()
+		}
+		return fieldName + " IN (" + strings.Join(placeholders, ",") + ")", args, nil
+	case "range":
+		pair := reflect.ValueOf(value)
+		if (pair.Kind() != reflect.Slice && pair.Kind() != reflect.Array) || pair.Len() != 2 {
+			return "", nil, fmt.Errorf("%s expects a slice of two values, got %v", field, value)
+		}
+		return fieldName + " BETWEEN ? AND ?", []interface{}{pair.Index(0).Interface(), pair.Index(1).Interface()}, nil
+	case "date":
+		day, ok := lookupDate(value)
+		if !ok {
+			return "", nil, fmt.Errorf("%s expects a time.Time or a YYYY-MM-DD string, got %v", field, value)
+		}
+		switch driver {
+		case "postgres":
+			return "CAST(" + fieldName + " AS DATE) = ?", []interface{}{day}, nil
+		case "mysql":
+			return "DATE(" + fieldName + ") = ?", []interface{}{day}, nil
 		}
-		condition = fieldName + " = ?"
+		return "date(" + fieldName + ") = ?", []interface{}{day}, nil
+	case "year":
+		year, ok := lookupYear(value)
+		if !ok {
+			return "", nil, fmt.Errorf("%s expects a year, got %v", field, value)
+		}
+		switch driver {
+		case "postgres":
+			return "EXTRACT(YEAR FROM " + fieldName + ") = ?", []interface{}{year}, nil
+		case "mysql":
+			return "YEAR(" + fieldName + ") = ?", []interface{}{year}, nil
+		}
+		return "CAST(strftime('%Y', " + fieldName + ") AS INTEGER) = ?", []interface{}{year}, nil
+	case "regex":
+		pattern, ok := value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%s expects a string pattern, got %T", field, value)
+		}
+		if driver == "postgres" {
+			return fieldName + " ~ ?", []interface{}{pattern}, nil
+		}
+		return fieldName + " REGEXP ?", []interface{}{pattern}, nil
 	case "isnull":
-		if value.(bool) {
-			condition = fieldName + " IS NULL"
-		} else {
-			condition = fieldName + " IS NOT NULL"
+		isNull, ok := value.(bool)
+		if !ok {
+			return "", nil, fmt.Errorf("%s expects a bool, got %T", field, value)
 		}
-		// Don't add value to args for NULL checks
-		goto skipValueAdd
+		if isNull {
+			return fieldName + " IS NULL", nil, nil
+		}
+		return fieldName + " IS NOT NULL", nil, nil
 	default:
-		condition = fieldName + " = ?"
+		return "", nil, fmt.Errorf("unsupported lookup %q in %q", lookup, field)
 	}
+}
 
-	newQS.where = append(newQS.where, condition)
-	newQS.args = append(newQS.args, value)
+// lookupDate returns the YYYY-MM-DD day a date lookup compares against
+func lookupDate(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format("2006-01-02"), true
+	case string:
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return v, true
+		}
+	}
+	return "", false
+}
 
-skipValueAdd:
-	return &newQS
+// lookupYear returns the year a year lookup compares against
+func lookupYear(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case string:
+		if year, err := strconv.Atoi(v); err == nil {
+			return year, true
+		}
+	case time.Time:
+		return v.Year(), true
+	}
+	return 0, false
 }
 
-// Exclude adds WHERE NOT conditions
-func (qs *QuerySet) Exclude(field string, value interface{}) *QuerySet {
-	// Similar to Filter but with NOT
-	newQS := qs.Filter(field, value)
-	// Modify the last condition to be NOT
-	if len(newQS.where) > 0 {
-		lastIndex := len(newQS.where) - 1
-		newQS.where[lastIndex] = "NOT (" + newQS.where[lastIndex] + ")"
+// Only limits the query to the given columns (Django's only), plus the
+// primary key, which is always loaded. Results are still models; the
+// fields of columns not selected keep their zero value.
+//
+//	users, err := qs.Only("id", "name").All() // SELECT id, name FROM users
+func (qs *QuerySet) Only(columns ...string) *QuerySet {
+	newQS := qs.Clone()
+	if err := qs.checkColumns(columns); err != nil {
+		newQS.setErr(err)
+		return newQS
+	}
+
+	newQS.columns = nil
+	for _, column := range database.Columns(qs.model) {
+		if column.PrimaryKey || contains(columns, column.Name) {
+			newQS.columns = append(newQS.columns, column.Name)
+		}
 	}
 	return newQS
 }
 
+// Defer leaves the given columns out of the query (Django's defer), e.g. to
+// skip large or sensitive fields. Their fields keep their zero value.
+//
+//	users, err := qs.Defer("password", "bio").All()
+func (qs *QuerySet) Defer(columns ...string) *QuerySet {
+	newQS := qs.Clone()
+	if err := qs.checkColumns(columns); err != nil {
+		newQS.setErr(err)
+		return newQS
+	}
+
+	selected := qs.columns
+	if selected == nil {
+		for _, column := range database.Columns(qs.model) {
+			selected = append(selected, column.Name)
+		}
+	}
+
+	newQS.columns = make([]string, 0, len(selected))
+	for _, column := range selected {
+		if !contains(columns, column) {
+			newQS.columns = append(newQS.columns, column)
+		}
+	}
+	if len(newQS.columns) == 0 {
+		newQS.setErr(fmt.Errorf("no columns of %s left to select after Defer", qs.tableName))
+	}
+	return newQS
+}
+
+// checkColumns returns an error if a name isn't one of the model's columns
+func (qs *QuerySet) checkColumns(names []string) error {
+	columns := database.Columns(qs.model)
+	for _, name := range names {
+		found := false
+		for _, column := range columns {
+			if column.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s has no column %q", qs.tableName, name)
+		}
+	}
+	return nil
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 // OrderBy adds ORDER BY clause
 func (qs *QuerySet) OrderBy(field string) *QuerySet {
-	newQS := *qs
+	newQS := qs.Clone()
 
 	// Handle Django-style ordering
 	if strings.HasPrefix(field, "-") {
@@ -138,34 +376,167 @@ func (qs *QuerySet) OrderBy(field string) *QuerySet {
 		newQS.orderBy = field + " ASC"
 	}
 
-	return &newQS
+	return newQS
 }
 
 // Limit adds LIMIT clause
 func (qs *QuerySet) Limit(limit int) *QuerySet {
-	newQS := *qs
+	newQS := qs.Clone()
 	newQS.limit = limit
-	return &newQS
+	return newQS
 }
 
 // Offset adds OFFSET clause
 func (qs *QuerySet) Offset(offset int) *QuerySet {
-	newQS := *qs
+	newQS := qs.Clone()
 	newQS.offset = offset
-	return &newQS
+	return newQS
 }
 
 // All executes the query and returns all results
 func (qs *QuerySet) All() (interface{}, error) {
-	sql := qs.buildSQL()
+	return qs.query(qs.model)
+}
+
+// Scan runs the query and stores the results in dest, a pointer to a slice
+// of structs or struct pointers, for typed results without All's type
+// assertion:
+//
+//	var users []User
+//	err := qs.Filter("active", true).Scan(&users)
+//
+// The struct needn't be the QuerySet's model: columns are matched by db tag,
+// and ones the struct doesn't map are skipped.
+func (qs *QuerySet) Scan(dest interface{}) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.IsNil() || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("scan destination must be a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+
+	elemType := slice.Type().Elem()
+	pointers := elemType.Kind() == reflect.Ptr
+	if pointers {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("scan destination must be a slice of structs, got %T", dest)
+	}
+
+	results, err := qs.query(newModel(elemType))
+	if err != nil {
+		return err
+	}
+
+	rows := reflect.ValueOf(results)
+	scanned := reflect.MakeSlice(slice.Type(), rows.Len(), rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		if pointers {
+			scanned.Index(i).Set(rows.Index(i))
+		} else {
+			scanned.Index(i).Set(rows.Index(i).Elem())
+		}
+	}
+	slice.Set(scanned)
+	return nil
+}
+
+// ScanOne stores the first result in dest, a pointer to a struct, or
+// returns ErrDoesNotExist when no row matches:
+//
+//	var user User
+//	err := qs.Filter("id", 1).ScanOne(&user)
+//	if errors.Is(err, gojango.ErrDoesNotExist) { ... }
+//
+// Like Scan, the struct needn't be the QuerySet's model.
+func (qs *QuerySet) ScanOne(dest interface{}) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan destination must be a pointer to a struct, got %T", dest)
+	}
+
+	limited := qs.Limit(1)
+	if limited.err != nil {
+		return limited.err
+	}
+
+	if mock := qs.db.Mock(); mock != nil {
+		records := limited.mockRecords(mock)
+		if len(records) == 0 {
+			return fmt.Errorf("%w in %s", ErrDoesNotExist, qs.tableName)
+		}
+		if err := setMockColumns(dest, records[0]); err != nil {
+			return err
+		}
+		if len(qs.prefetch) > 0 {
+			return qs.db.PrefetchContext(qs.context(), dest, qs.prefetch...)
+		}
+		return nil
+	}
+
+	rows, err := qs.db.QueryContext(qs.context(), limited.buildSQL(), limited.args...)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	if err := qs.db.ScanRow(rows, dest); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w in %s", ErrDoesNotExist, qs.tableName)
+		}
+		return err
+	}
+
+	if len(qs.prefetch) > 0 {
+		return qs.db.PrefetchContext(qs.context(), dest, qs.prefetch...)
+	}
+	return nil
+}
+
+// query runs the query and scans the rows into a slice of pointers to
+// model's type, loading any prefetched relations
+func (qs *QuerySet) query(model interface{}) (interface{}, error) {
+	if qs.err != nil {
+		return nil, qs.err
+	}
 
-	rows, err := qs.db.Conn.Query(sql, qs.args...)
+	var results interface{}
+	var err error
+	if mock := qs.db.Mock(); mock != nil {
+		results, err = mockModels(qs.mockRecords(mock), model)
+	} else {
+		results, err = qs.scan(model)
+	}
+	if err != nil || len(qs.prefetch) == 0 {
+		return results, err
+	}
+
+	if err := qs.db.PrefetchContext(qs.context(), results, qs.prefetch...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scan runs the query's SQL and scans the rows into a slice of pointers to
+// model's type
+func (qs *QuerySet) scan(model interface{}) (interface{}, error) {
+	rows, err := qs.db.QueryContext(qs.context(), qs.buildSQL(), qs.args...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %v", err)
 	}
 	defer rows.Close()
 
-	return qs.db.ScanRows(rows, qs.model)
+	return qs.db.ScanRows(rows, model)
+}
+
+// Prefetch loads the named many-to-many fields of the results along with
+// them, using one query per field rather than one per result:
+//
+//	posts, err := qs.Filter("published", true).Prefetch("Tags").All()
+func (qs *QuerySet) Prefetch(fields ...string) *QuerySet {
+	newQS := qs.Clone()
+	newQS.prefetch = append(newQS.prefetch, fields...)
+	return newQS
 }
 
 // First returns the first result
@@ -182,11 +553,19 @@ func (qs *QuerySet) First() (interface{}, error) {
 		return resultsValue.Index(0).Interface(), nil
 	}
 
-	return nil, fmt.Errorf("no results found")
+	return nil, fmt.Errorf("%w in %s", ErrDoesNotExist, qs.tableName)
 }
 
 // Count returns the count of matching records
 func (qs *QuerySet) Count() (int, error) {
+	if qs.err != nil {
+		return 0, qs.err
+	}
+
+	if mock := qs.db.Mock(); mock != nil {
+		return len(mock.Find(qs.tableName, qs.matches)), nil
+	}
+
 	sql := fmt.Sprintf("SELECT COUNT(*) FROM %s", qs.tableName)
 
 	if len(qs.where) > 0 {
@@ -194,7 +573,7 @@ func (qs *QuerySet) Count() (int, error) {
 	}
 
 	var count int
-	err := qs.db.Conn.QueryRow(sql, qs.args...).Scan(&count)
+	err := qs.db.QueryRowContext(qs.context(), sql, qs.args...).Scan(&count)
 	return count, err
 }
 
@@ -206,7 +585,14 @@ func (qs *QuerySet) Exists() (bool, error) {
 
 // buildSQL builds the complete SQL query
 func (qs *QuerySet) buildSQL() string {
-	sql := fmt.Sprintf("SELECT * FROM %s", qs.tableName)
+	selectList := "*"
+	if len(qs.columns) > 0 {
+		selectList = strings.Join(qs.columns, ", ")
+	}
+	if len(qs.annotations) > 0 {
+		selectList += ", " + strings.Join(qs.annotations, ", ")
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", selectList, qs.tableName)
 
 	if len(qs.where) > 0 {
 		sql += " WHERE " + strings.Join(qs.where, " AND ")
@@ -227,18 +613,43 @@ func (qs *QuerySet) buildSQL() string {
 	return sql
 }
 
-// Update updates matching records
-func (qs *QuerySet) Update(data map[string]interface{}) error {
+// Update updates matching records with a single UPDATE statement and
+// returns how many rows it changed:
+//
+//	n, err := qs.Filter("active", false).Update(map[string]interface{}{"status": "archived"})
+//
+// The keys must be columns of the model; others are an error.
+// Model hooks (BeforeUpdate, AfterUpdate) don't run for bulk updates; use
+// DB.Update on each record when they must. With the mock database the count
+// is the number of records the filters matched.
+func (qs *QuerySet) Update(data map[string]interface{}) (int64, error) {
+	if qs.err != nil {
+		return 0, qs.err
+	}
 	if len(data) == 0 {
-		return fmt.Errorf("no data to update")
+		return 0, fmt.Errorf("no data to update")
+	}
+
+	// The keys become SQL, so only the model's columns are accepted
+	fields := make([]string, 0, len(data))
+	for field := range data {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	if err := qs.checkColumns(fields); err != nil {
+		return 0, err
+	}
+
+	if mock := qs.db.Mock(); mock != nil {
+		return mock.UpdateWhere(qs.tableName, qs.matches, data), nil
 	}
 
 	var setParts []string
 	var args []interface{}
 
-	for field, value := range data {
+	for _, field := range fields {
 		setParts = append(setParts, field+" = ?")
-		args = append(args, value)
+		args = append(args, data[field])
 	}
 
 	sql := fmt.Sprintf("UPDATE %s SET %s", qs.tableName, strings.Join(setParts, ", "))
@@ -248,20 +659,78 @@ func (qs *QuerySet) Update(data map[string]interface{}) error {
 		args = append(args, qs.args...)
 	}
 
-	_, err := qs.db.Conn.Exec(sql, args...)
-	return err
+	result, err := qs.db.ExecContext(qs.context(), sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-// Delete deletes matching records
-func (qs *QuerySet) Delete() error {
-	sql := fmt.Sprintf("DELETE FROM %s", qs.tableName)
+// Delete deletes matching records, applying the on_delete rules of related
+// tables (see database.DB.DeleteWhereContext), and returns how many rows of
+// the QuerySet's table it removed. Model hooks (BeforeDelete, AfterDelete)
+// don't run for bulk deletes; use DB.Delete on each record when they must.
+// With the mock database the count is the number of records the filters
+// matched, and on_delete rules aren't applied.
+func (qs *QuerySet) Delete() (int64, error) {
+	if qs.err != nil {
+		return 0, qs.err
+	}
 
-	if len(qs.where) > 0 {
-		sql += " WHERE " + strings.Join(qs.where, " AND ")
+	if mock := qs.db.Mock(); mock != nil {
+		return mock.DeleteWhere(qs.tableName, qs.matches), nil
 	}
 
-	_, err := qs.db.Conn.Exec(sql, qs.args...)
-	return err
+	return qs.db.DeleteWhereContext(qs.context(), qs.model, strings.Join(qs.where, " AND "), qs.args...)
+}
+
+// Page is one page of QuerySet results. It marshals to the envelope
+//
+//	{"results": [...], "count": 42, "page": 2, "num_pages": 5}
+type Page struct {
+	Results  interface{} `json:"results"`
+	Count    int         `json:"count"`
+	Page     int         `json:"page"`
+	NumPages int         `json:"num_pages"`
+}
+
+// Paginate returns page number page (starting at 1) of pageSize results,
+// with the total count. Out-of-range page numbers are clamped to the first
+// or last page, like Django's Paginator.get_page. It can be returned from a
+// handler as is:
+//
+//	page, err := qs.OrderBy("id").Paginate(c.QueryIntDefault("page", 1), 20)
+//	if err != nil {
+//		return c.ErrorJSON(500, "Database error", err)
+//	}
+//	return c.JSON(page)
+func (qs *QuerySet) Paginate(page, pageSize int) (*Page, error) {
+	if pageSize < 1 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	count, err := qs.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	numPages := (count + pageSize - 1) / pageSize
+	if numPages == 0 {
+		numPages = 1
+	}
+	if page < 1 {
+		page = 1
+	}
+	if page > numPages {
+		page = numPages
+	}
+
+	results, err := qs.Limit(pageSize).Offset((page - 1) * pageSize).All()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{Results: results, Count: count, Page: page, NumPages: numPages}, nil
 }
 
 // ToJSON converts results to JSON
@@ -278,3 +747,19 @@ func (qs *QuerySet) ToJSON() (string, error) {
 
 	return string(jsonBytes), nil
 }
+
+// ToJSONPaginated converts one page of results to JSON, in the envelope
+// described on Page
+func (qs *QuerySet) ToJSONPaginated(page, pageSize int) (string, error) {
+	result, err := qs.Paginate(page, pageSize)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
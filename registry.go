@@ -0,0 +1,44 @@
+package gojango
+
+import "reflect"
+
+// registerModel records model under its table and struct names, so Model
+// can resolve either, and returns its struct type
+func (app *App) registerModel(model interface{}) reflect.Type {
+	modelType := structType(model)
+
+	if app.registry == nil {
+		app.registry = make(map[string]reflect.Type)
+	}
+	app.registry[app.db.GetTableName(model)] = modelType
+	app.registry[modelType.Name()] = modelType
+	return modelType
+}
+
+// Model returns a new, zero instance (a struct pointer) of the model
+// registered under name, its table name or its struct name. Models are
+// registered by AutoMigrate, RegisterCRUD and RegisterAdmin:
+//
+//	app.AutoMigrate(&User{})
+//	user, ok := app.Model("users") // or app.Model("User"); user is a *User
+func (app *App) Model(name string) (interface{}, bool) {
+	modelType, ok := app.registry[name]
+	if !ok {
+		return nil, false
+	}
+	return newModel(modelType), true
+}
+
+// structType returns the struct type of a model or model pointer
+func structType(model interface{}) reflect.Type {
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	return modelType
+}
+
+// newModel returns a pointer to a new zero value of modelType
+func newModel(modelType reflect.Type) interface{} {
+	return reflect.New(modelType).Interface()
+}
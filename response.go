@@ -0,0 +1,93 @@
+package gojango
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// responseWriter defers writing the status line until the first body write
+// (or the end of the request), so handlers can call Status and Header in any
+// order before sending a body
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	size        int
+}
+
+// newResponseWriter wraps w with deferred header writing
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+// WriteHeader records the status code; it is sent with the first body write.
+// Calls after the header has been sent are ignored.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = code
+}
+
+// Write sends the pending header, then the body bytes
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.writeHeaderNow()
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// writeHeaderNow sends the pending status (200 if none was set) once
+func (w *responseWriter) writeHeaderNow() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Status returns the status code sent, or pending, for the response
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Size returns the number of body bytes written
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+// Flush sends the pending header and any buffered data to the client
+func (w *responseWriter) Flush() {
+	w.writeHeaderNow()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection (e.g. for WebSockets).
+// The status line is then the caller's responsibility.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,7 +1,11 @@
 package router
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -9,60 +13,128 @@ import (
 // Router handles HTTP routing with parameter extraction
 type Router struct {
 	routes map[string][]*Route
+	names  map[string]*Route
 	mux    *http.ServeMux
+
+	// ordered lists every route in registration order
+	ordered []*Route
+
+	// AutoHead answers HEAD requests with the matching GET route, discarding
+	// the body (enabled by default)
+	AutoHead bool
+
+	// TrailingSlash decides what happens to a path that only matches a route
+	// once a trailing slash is added or removed (TrailingSlashRedirect by
+	// default)
+	TrailingSlash TrailingSlashMode
+
+	// normalize is set with SetPathNormalization
+	normalize PathNormalization
+}
+
+// PathNormalization controls how request paths are rewritten before they are
+// matched against routes. The zero value matches paths exactly.
+type PathNormalization struct {
+	// CaseInsensitive matches routes regardless of case, so /API/Users hits
+	// /api/users. Parameters keep the case they were sent with.
+	CaseInsensitive bool
+	// Clean collapses duplicate slashes and resolves "." and ".." segments
+	// with path.Clean, keeping a trailing slash. Handlers see the cleaned
+	// path in Request.URL.Path.
+	Clean bool
 }
 
+// TrailingSlashMode is how the router treats "/users/" when only "/users" is
+// registered, or the other way round
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashRedirect redirects to the path with the route's form:
+	// 301 for GET and HEAD, 308 for other methods so the body is resent
+	TrailingSlashRedirect TrailingSlashMode = iota
+	// TrailingSlashMatch serves both forms with the same route
+	TrailingSlashMatch
+	// TrailingSlashStrict answers 404, matching paths exactly
+	TrailingSlashStrict
+)
+
 // Route represents a single route
 type Route struct {
+	Method  string
 	Pattern string
 	Handler http.HandlerFunc
 	Regex   *regexp.Regexp
 	Params  []string
+	router  *Router
+	name    string
+
+	// folded is Regex matching regardless of case
+	folded *regexp.Regexp
 }
 
+// paramPattern matches :param segments in a route pattern
+var paramPattern = regexp.MustCompile(`:([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// paramsKey is the request context key holding the matched route parameters
+type paramsKey struct{}
+
+// patternKey is the request context key holding the matched route pattern
+type patternKey struct{}
+
 // New creates a new router
 func New() *Router {
 	return &Router{
 		routes: make(map[string][]*Route),
+		names:  make(map[string]*Route),
 		mux:    http.NewServeMux(),
+
+		AutoHead: true,
 	}
 }
 
 // GET registers a GET route
-func (r *Router) GET(pattern string, handler http.HandlerFunc) {
-	r.addRoute("GET", pattern, handler)
+func (r *Router) GET(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("GET", pattern, handler)
 }
 
 // POST registers a POST route
-func (r *Router) POST(pattern string, handler http.HandlerFunc) {
-	r.addRoute("POST", pattern, handler)
+func (r *Router) POST(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("POST", pattern, handler)
 }
 
 // PUT registers a PUT route
-func (r *Router) PUT(pattern string, handler http.HandlerFunc) {
-	r.addRoute("PUT", pattern, handler)
+func (r *Router) PUT(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("PUT", pattern, handler)
 }
 
 // DELETE registers a DELETE route
-func (r *Router) DELETE(pattern string, handler http.HandlerFunc) {
-	r.addRoute("DELETE", pattern, handler)
+func (r *Router) DELETE(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("DELETE", pattern, handler)
 }
 
 // PATCH registers a PATCH route
-func (r *Router) PATCH(pattern string, handler http.HandlerFunc) {
-	r.addRoute("PATCH", pattern, handler)
+func (r *Router) PATCH(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("PATCH", pattern, handler)
+}
+
+// OPTIONS registers an OPTIONS route
+func (r *Router) OPTIONS(pattern string, handler http.HandlerFunc) *Route {
+	return r.addRoute("OPTIONS", pattern, handler)
 }
 
 // addRoute adds a route to the router
-func (r *Router) addRoute(method, pattern string, handler http.HandlerFunc) {
+func (r *Router) addRoute(method, pattern string, handler http.HandlerFunc) *Route {
 	route := &Route{
+		Method:  method,
 		Pattern: pattern,
 		Handler: handler,
+		router:  r,
 	}
 	
 	// Convert pattern to regex for parameter extraction
 	regexPattern, params := r.patternToRegex(pattern)
 	route.Regex = regexp.MustCompile("^" + regexPattern + "$")
+	route.folded = regexp.MustCompile("(?i)^" + regexPattern + "$")
 	route.Params = params
 	
 	if r.routes[method] == nil {
@@ -70,6 +142,68 @@ func (r *Router) addRoute(method, pattern string, handler http.HandlerFunc) {
 	}
 	
 	r.routes[method] = append(r.routes[method], route)
+	r.ordered = append(r.ordered, route)
+	return route
+}
+
+// SetPathNormalization sets how request paths are normalized before
+// matching; by default they aren't
+func (r *Router) SetPathNormalization(opts PathNormalization) {
+	r.normalize = opts
+}
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	Method  string   `json:"method"`
+	Pattern string   `json:"pattern"`
+	Params  []string `json:"params"`
+	// Name is the name given with Route.Name, if any
+	Name string `json:"name,omitempty"`
+}
+
+// Routes returns every registered route in registration order
+func (r *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(r.ordered))
+	for i, route := range r.ordered {
+		routes[i] = RouteInfo{
+			Method:  route.Method,
+			Pattern: route.Pattern,
+			Params:  append([]string(nil), route.Params...),
+			Name:    route.name,
+		}
+	}
+	return routes
+}
+
+// Name registers a name for the route so its URL can be built with URL
+func (route *Route) Name(name string) *Route {
+	route.router.names[name] = route
+	route.name = name
+	return route
+}
+
+// URL builds the path of a named route, substituting its :params
+func (r *Router) URL(name string, params map[string]string) (string, error) {
+	route, exists := r.names[name]
+	if !exists {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+
+	var missing []string
+	path := paramPattern.ReplaceAllStringFunc(route.Pattern, func(match string) string {
+		value, ok := params[match[1:]]
+		if !ok || value == "" {
+			missing = append(missing, match[1:])
+			return match
+		}
+		return url.PathEscape(value)
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("route %q is missing parameters: %s", name, strings.Join(missing, ", "))
+	}
+
+	return path, nil
 }
 
 // patternToRegex converts a route pattern to regex
@@ -77,9 +211,7 @@ func (r *Router) patternToRegex(pattern string) (string, []string) {
 	var params []string
 	
 	// Replace :param with ([^/]+) and collect parameter names
-	paramRegex := regexp.MustCompile(`:([a-zA-Z_][a-zA-Z0-9_]*)`)
-	
-	regexPattern := paramRegex.ReplaceAllStringFunc(pattern, func(match string) string {
+	regexPattern := paramPattern.ReplaceAllStringFunc(pattern, func(match string) string {
 		paramName := match[1:] // Remove the :
 		params = append(params, paramName)
 		return `([^/]+)` // Match any character except /
@@ -94,49 +226,163 @@ func (r *Router) patternToRegex(pattern string) (string, []string) {
 
 // ServeHTTP implements http.Handler
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	method := req.Method
-	path := req.URL.Path
-	
-	// Find matching route
-	routes, exists := r.routes[method]
-	if !exists {
+	if r.normalize.Clean {
+		if cleaned := cleanPath(req.URL.Path); cleaned != req.URL.Path {
+			// Copy the request rather than change the caller's, as
+			// http.StripPrefix does
+			clone := new(http.Request)
+			*clone = *req
+			clone.URL = new(url.URL)
+			*clone.URL = *req.URL
+			clone.URL.Path = cleaned
+			clone.URL.RawPath = ""
+			req = clone
+		}
+	}
+
+	route, params, head := r.find(req.Method, req.URL.Path)
+
+	// Try the path with its trailing slash toggled. Catch-all routes already
+	// match both forms, so they never get here.
+	if route == nil && r.TrailingSlash != TrailingSlashStrict {
+		if alternate, ok := toggleSlash(req.URL.Path); ok {
+			route, params, head = r.find(req.Method, alternate)
+			if route != nil && r.TrailingSlash == TrailingSlashRedirect {
+				r.redirect(w, req, alternate)
+				return
+			}
+		}
+	}
+
+	if route == nil {
 		http.NotFound(w, req)
 		return
 	}
-	
-	for _, route := range routes {
-		matches := route.Regex.FindStringSubmatch(path)
-		if matches != nil {
-			// Extract parameters
-			params := make(map[string]string)
-			for i, paramName := range route.Params {
-				if i+1 < len(matches) {
-					params[paramName] = matches[i+1]
-				}
+
+	// Answer HEAD with the GET route, without sending the body
+	if head {
+		w = &headResponseWriter{w}
+	}
+
+	// Store parameters and the matched pattern in the request context
+	ctx := context.WithValue(req.Context(), paramsKey{}, params)
+	ctx = context.WithValue(ctx, patternKey{}, route.Pattern)
+	req = req.WithContext(ctx)
+
+	route.Handler(w, req)
+}
+
+// find matches method and path, falling back to the GET route for HEAD when
+// AutoHead is on; head reports that fallback
+func (r *Router) find(method, path string) (route *Route, params map[string]string, head bool) {
+	route, params = r.match(method, path)
+	if route == nil && method == http.MethodHead && r.AutoHead {
+		route, params = r.match(http.MethodGet, path)
+		head = route != nil
+	}
+	return route, params, head
+}
+
+// toggleSlash adds a trailing slash to path, or removes it. The root path and
+// paths that would start with "//", which browsers read as another host, have
+// no alternate.
+func toggleSlash(path string) (string, bool) {
+	if path == "/" || path == "" {
+		return "", false
+	}
+	if strings.HasSuffix(path, "/") {
+		path = path[:len(path)-1]
+	} else {
+		path += "/"
+	}
+	if strings.HasPrefix(path, "//") {
+		return "", false
+	}
+	return path, true
+}
+
+// cleanPath cleans p with path.Clean, keeping its trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// redirect sends the client to path, keeping the query string
+func (r *Router) redirect(w http.ResponseWriter, req *http.Request, path string) {
+	target := url.URL{Path: path, RawQuery: req.URL.RawQuery}
+	code := http.StatusPermanentRedirect
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, req, target.String(), code)
+}
+
+// match finds the route for method and path and extracts its parameters
+func (r *Router) match(method, path string) (*Route, map[string]string) {
+	for _, route := range r.routes[method] {
+		regex := route.Regex
+		if r.normalize.CaseInsensitive {
+			regex = route.folded
+		}
+		matches := regex.FindStringSubmatch(path)
+		if matches == nil {
+			continue
+		}
+
+		params := make(map[string]string)
+		for i, paramName := range route.Params {
+			if i+1 < len(matches) {
+				params[paramName] = matches[i+1]
 			}
-			
-			// Store parameters in request context (simplified approach)
-			// In a real implementation, you'd use context.Context
-			req.Header.Set("X-Route-Params", r.encodeParams(params))
-			
-			route.Handler(w, req)
-			return
 		}
+		return route, params
 	}
-	
-	http.NotFound(w, req)
+	return nil, nil
+}
+
+// headResponseWriter keeps headers and status but discards the body
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write discards b, reporting it as written
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
 }
 
-// encodeParams encodes parameters for header storage (simplified)
-func (r *Router) encodeParams(params map[string]string) string {
-	var parts []string
-	for k, v := range params {
-		parts = append(parts, k+"="+v)
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Params returns the route parameters matched for req, or an empty map
+func Params(req *http.Request) map[string]string {
+	if params, ok := req.Context().Value(paramsKey{}).(map[string]string); ok {
+		return params
 	}
-	return strings.Join(parts, "&")
+	return map[string]string{}
+}
+
+// Pattern returns the pattern of the route matched for req (e.g.
+// "/users/:id"), or "" if the request wasn't routed
+func Pattern(req *http.Request) string {
+	pattern, _ := req.Context().Value(patternKey{}).(string)
+	return pattern
 }
 
-// DecodeParams decodes parameters from header (helper function)
+// DecodeParams decodes parameters in the "k=v&k2=v2" form previously used to
+// pass them through a request header.
+//
+// Deprecated: route parameters are now stored in the request context; use Params.
 func DecodeParams(encoded string) map[string]string {
 	params := make(map[string]string)
 	if encoded == "" {
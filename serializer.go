@@ -0,0 +1,122 @@
+package gojango
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gojango/models"
+)
+
+// CRUDOption configures the endpoints created by RegisterCRUD
+type CRUDOption func(*crudOptions)
+
+// crudOptions holds the settings applied by CRUDOption values
+type crudOptions struct {
+	// include and exclude are JSON field names keyed by view
+	include map[string][]string
+	exclude map[string][]string
+	// bulkDelete registers DELETE basePath for deleting many records
+	bulkDelete bool
+}
+
+// IncludeFields limits the view's JSON to the given fields (JSON names):
+//
+//	app.RegisterCRUD("/api/users", &User{},
+//		gojango.IncludeFields(models.ViewList, "id", "name"))
+func IncludeFields(view string, fields ...string) CRUDOption {
+	return func(o *crudOptions) {
+		if o.include == nil {
+			o.include = make(map[string][]string)
+		}
+		o.include[view] = append(o.include[view], fields...)
+	}
+}
+
+// ExcludeFields leaves the given fields (JSON names) out of the view's JSON
+func ExcludeFields(view string, fields ...string) CRUDOption {
+	return func(o *crudOptions) {
+		if o.exclude == nil {
+			o.exclude = make(map[string][]string)
+		}
+		o.exclude[view] = append(o.exclude[view], fields...)
+	}
+}
+
+// AllowBulkDelete registers DELETE basePath, which deletes every record
+// whose primary key is listed in the body with one statement and reports
+// how many went:
+//
+//	DELETE /api/users  {"ids": [1, 2, 3]}  ->  {"deleted": 3}
+//
+// Models with a composite primary key answer it with 400.
+// Like QuerySet.Delete it applies on_delete rules but skips the model's
+// delete hooks. It is off by default so a stray request can't empty a table.
+func AllowBulkDelete() CRUDOption {
+	return func(o *crudOptions) {
+		o.bulkDelete = true
+	}
+}
+
+// serialize returns the representation of a record for view: what its
+// Serialize method returns if it is a models.Serializer, otherwise its JSON
+// filtered by the configured field sets
+func (o *crudOptions) serialize(record interface{}, view string) (interface{}, error) {
+	if serializer, ok := record.(models.Serializer); ok {
+		return serializer.Serialize(view), nil
+	}
+
+	include, exclude := o.include[view], o.exclude[view]
+	if len(include) == 0 && len(exclude) == 0 {
+		return record, nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("cannot select fields of %T: %v", record, err)
+	}
+
+	if len(include) > 0 {
+		selected := make(map[string]json.RawMessage, len(include))
+		for _, name := range include {
+			if value, ok := fields[name]; ok {
+				selected[name] = value
+			}
+		}
+		fields = selected
+	}
+	for _, name := range exclude {
+		delete(fields, name)
+	}
+	return fields, nil
+}
+
+// serializeList serializes each record of a slice for the list view
+func (o *crudOptions) serializeList(records interface{}) (interface{}, error) {
+	value := reflect.ValueOf(records)
+	if value.Kind() != reflect.Slice {
+		return records, nil
+	}
+
+	// Nothing to change: keep the slice as the database returned it
+	serializerType := reflect.TypeOf((*models.Serializer)(nil)).Elem()
+	if !value.Type().Elem().Implements(serializerType) &&
+		len(o.include[models.ViewList]) == 0 && len(o.exclude[models.ViewList]) == 0 {
+		return records, nil
+	}
+
+	items := make([]interface{}, value.Len())
+	for i := range items {
+		item, err := o.serialize(value.Index(i).Interface(), models.ViewList)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
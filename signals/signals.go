@@ -0,0 +1,118 @@
+// Package signals lets code react to model changes without editing the code
+// that makes them, like Django's signals. The database package sends
+// PreSave and PostSave around Create and Update, and PreDelete and PostDelete
+// around Delete:
+//
+//	signals.Connect(signals.PostSave, func(e signals.Event) {
+//		if user, ok := e.Model.(*User); ok {
+//			cache.Delete("user:" + strconv.Itoa(int(user.ID)))
+//		}
+//	})
+//
+// Receivers run synchronously, in the goroutine making the change, in the
+// order they were connected. Sending a signal nobody receives costs an
+// atomic load.
+package signals
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Signals sent by the database package
+const (
+	PreSave    = "pre_save"
+	PostSave   = "post_save"
+	PreDelete  = "pre_delete"
+	PostDelete = "post_delete"
+)
+
+// Event describes a change a receiver is notified of
+type Event struct {
+	// Signal is the name of the signal sent
+	Signal string
+	// Model is the instance being saved or deleted
+	Model interface{}
+	// ID is the key passed to Update or Delete; nil for Create
+	ID interface{}
+	// Created is true when a save inserts a new row
+	Created bool
+	// Context is the context the change runs with, carrying request values
+	Context context.Context
+}
+
+// Receiver handles an event
+type Receiver func(Event)
+
+// receiver is a connected Receiver; the pointer identifies it for Disconnect
+type receiver struct {
+	fn Receiver
+}
+
+var (
+	mu        sync.RWMutex
+	receivers = make(map[string][]*receiver)
+	// connected counts every connected receiver, so Send can return at once
+	// when there are none
+	connected atomic.Int64
+)
+
+// Connect registers fn for signal and returns a function that disconnects it
+func Connect(signal string, fn Receiver) (disconnect func()) {
+	r := &receiver{fn: fn}
+
+	mu.Lock()
+	receivers[signal] = append(receivers[signal], r)
+	mu.Unlock()
+	connected.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			list := receivers[signal]
+			for i, existing := range list {
+				if existing == r {
+					receivers[signal] = append(list[:i:i], list[i+1:]...)
+					connected.Add(-1)
+					return
+				}
+			}
+		})
+	}
+}
+
+// HasReceivers reports whether any receiver is connected to signal
+func HasReceivers(signal string) bool {
+	if connected.Load() == 0 {
+		return false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(receivers[signal]) > 0
+}
+
+// Send calls every receiver of e.Signal with e. A nil Context is replaced
+// with context.Background().
+func Send(e Event) {
+	if connected.Load() == 0 {
+		return
+	}
+
+	mu.RLock()
+	list := receivers[e.Signal]
+	mu.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+
+	if e.Context == nil {
+		e.Context = context.Background()
+	}
+	for _, r := range list {
+		r.fn(e)
+	}
+}
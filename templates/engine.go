@@ -1,98 +1,282 @@
 package templates
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
-// Engine handles template rendering
+// Engine handles template rendering. It is safe for concurrent use.
 type Engine struct {
+	mu        sync.RWMutex
 	templates map[string]*template.Template
-	baseDir   string
-	funcMap   template.FuncMap
+	// master is the parsed set templates is cloned from; it is never
+	// executed, so RenderWith can clone it again with other functions
+	master     *template.Template
+	baseDir    string
+	extensions []string
+	funcMap    template.FuncMap
+	autoReload bool
 }
 
 // New creates a new template engine
 func New() *Engine {
 	return &Engine{
-		templates: make(map[string]*template.Template),
-		baseDir:   "templates",
-		funcMap:   defaultFuncMap(),
+		templates:  make(map[string]*template.Template),
+		baseDir:    "templates",
+		extensions: []string{".html"},
+		funcMap:    defaultFuncMap(),
 	}
 }
 
 // SetBaseDir sets the base directory for templates
 func (e *Engine) SetBaseDir(dir string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.baseDir = dir
 }
 
+// SetAutoReload makes Render re-parse templates from disk on every call, so
+// edits show up without a restart. Meant for development: the app enables
+// it when Config.Debug is set. When disabled, parsed templates are cached.
+func (e *Engine) SetAutoReload(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.autoReload = enabled
+}
+
+// SetExtensions sets the file extensions loaded as templates (default ".html")
+//
+//	engine.SetExtensions(".html", ".tmpl", ".gohtml")
+func (e *Engine) SetExtensions(extensions ...string) {
+	normalized := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, ext)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.extensions = normalized
+}
+
 // AddFunc adds a function to the template function map
 func (e *Engine) AddFunc(name string, fn interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if e.funcMap == nil {
 		e.funcMap = make(template.FuncMap)
 	}
 	e.funcMap[name] = fn
 }
 
-// LoadTemplates loads all templates from the base directory
+// LoadTemplates loads all templates under the base directory, including
+// subdirectories. Templates are named by their path relative to the base
+// directory without the extension ("index", "partials/header") and parsed
+// into one set, so they can include each other:
+//
+//	{{template "partials/header" .}}
 func (e *Engine) LoadTemplates() error {
-	if e.baseDir == "" {
+	e.mu.RLock()
+	baseDir := e.baseDir
+	e.mu.RUnlock()
+	
+	if baseDir == "" {
 		return nil
 	}
 	
-	pattern := filepath.Join(e.baseDir, "*.html")
-	templates, err := filepath.Glob(pattern)
+	templates, master, err := e.parseAll()
 	if err != nil {
-		return fmt.Errorf("failed to load templates: %v", err)
+		return err
 	}
 	
-	for _, templateFile := range templates {
-		name := strings.TrimSuffix(filepath.Base(templateFile), ".html")
+	e.mu.Lock()
+	e.templates, e.master = templates, master
+	e.mu.Unlock()
+	return nil
+}
+
+// parseAll walks the base directory and parses every template file into a
+// single set, returning a clone's templates by name and the set itself
+func (e *Engine) parseAll() (map[string]*template.Template, *template.Template, error) {
+	e.mu.RLock()
+	baseDir, extensions := e.baseDir, e.extensions
+	set := template.New("").Funcs(e.funcMap)
+	e.mu.RUnlock()
+	
+	templates := make(map[string]*template.Template)
+	
+	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		
+		ext := templateExtension(path, extensions)
+		if d.IsDir() || ext == "" {
+			return nil
+		}
+		
+		rel, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, ext))
 		
-		tmpl, err := template.New(name).Funcs(e.funcMap).ParseFiles(templateFile)
+		content, err := os.ReadFile(path)
 		if err != nil {
-			return fmt.Errorf("failed to parse template %s: %v", templateFile, err)
+			return err
 		}
 		
-		e.templates[name] = tmpl
+		if _, err := set.New(name).Parse(string(content)); err != nil {
+			return fmt.Errorf("failed to parse template %s: %v", path, err)
+		}
+		
+		templates[name] = nil
+		return nil
+	})
+	
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to load templates: %v", err)
 	}
 	
-	return nil
+	rendered, err := set.Clone()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load templates: %v", err)
+	}
+	for name := range templates {
+		templates[name] = rendered.Lookup(name)
+	}
+	return templates, set, nil
 }
 
-// Render renders a template with data
+// templateExtension returns the extension in extensions that path ends with, or ""
+func templateExtension(path string, extensions []string) string {
+	for _, ext := range extensions {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// bufferPool recycles the buffers templates are rendered into
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Render renders a template with data. The output is buffered and written
+// to w only if the template executes successfully, so an error never leaves
+// a truncated page behind.
 func (e *Engine) Render(w io.Writer, name string, data interface{}) error {
+	e.mu.RLock()
 	tmpl, exists := e.templates[name]
-	if !exists {
-		// Try to load the template dynamically
-		if err := e.loadTemplate(name); err != nil {
+	reload := e.autoReload
+	e.mu.RUnlock()
+	
+	if !exists || reload {
+		// Load the template (again) from disk
+		var err error
+		tmpl, err = e.loadTemplate(name)
+		if err != nil {
 			return fmt.Errorf("template %s not found: %v", name, err)
 		}
-		tmpl = e.templates[name]
 	}
 	
-	return tmpl.Execute(w, data)
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	
+	if err := tmpl.Execute(buf, data); err != nil {
+		return err
+	}
+	
+	_, err := buf.WriteTo(w)
+	return err
 }
 
-// loadTemplate loads a single template
-func (e *Engine) loadTemplate(name string) error {
-	templateFile := filepath.Join(e.baseDir, name+".html")
+// RenderWith renders a template like Render, with funcs replacing template
+// functions of the same name for this call only. It lets per-request data
+// be reached from a template function; a function must already exist (see
+// AddFunc) when the templates are parsed for a template to call it. Each
+// call clones the template set, so it costs more than Render.
+func (e *Engine) RenderWith(w io.Writer, name string, data interface{}, funcs template.FuncMap) error {
+	if len(funcs) == 0 {
+		return e.Render(w, name, data)
+	}
+	
+	e.mu.RLock()
+	_, exists := e.templates[name]
+	master := e.master
+	reload := e.autoReload
+	e.mu.RUnlock()
+	
+	if !exists || reload || master == nil {
+		if _, err := e.loadTemplate(name); err != nil {
+			return fmt.Errorf("template %s not found: %v", name, err)
+		}
+		e.mu.RLock()
+		master = e.master
+		e.mu.RUnlock()
+	}
 	
-	tmpl, err := template.New(name).Funcs(e.funcMap).ParseFiles(templateFile)
+	set, err := master.Clone()
 	if err != nil {
 		return err
 	}
+	tmpl := set.Funcs(funcs).Lookup(name)
 	
-	e.templates[name] = tmpl
-	return nil
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	
+	if err := tmpl.Execute(buf, data); err != nil {
+		return err
+	}
+	
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// loadTemplate re-parses the template set from disk, picking up edits and
+// templates added since the last load, and returns the named template
+func (e *Engine) loadTemplate(name string) (*template.Template, error) {
+	templates, master, err := e.parseAll()
+	if err != nil {
+		return nil, err
+	}
+	
+	tmpl, exists := templates[name]
+	if !exists {
+		return nil, fmt.Errorf("no template file for %q", name)
+	}
+	
+	e.mu.Lock()
+	e.templates, e.master = templates, master
+	e.mu.Unlock()
+	return tmpl, nil
 }
 
 // RenderString renders a template string directly
 func (e *Engine) RenderString(templateStr string, data interface{}) (string, error) {
-	tmpl, err := template.New("inline").Funcs(e.funcMap).Parse(templateStr)
+	e.mu.RLock()
+	tmpl := template.New("inline").Funcs(e.funcMap)
+	e.mu.RUnlock()
+	
+	tmpl, err := tmpl.Parse(templateStr)
 	if err != nil {
 		return "", err
 	}
@@ -110,7 +294,9 @@ func defaultFuncMap() template.FuncMap {
 	return template.FuncMap{
 		"upper": strings.ToUpper,
 		"lower": strings.ToLower,
-		"title": strings.Title,
+		"title": func(s string) string {
+			return cases.Title(language.Und).String(s)
+		},
 		"join":  strings.Join,
 		"add": func(a, b int) int {
 			return a + b
@@ -157,5 +343,81 @@ func defaultFuncMap() template.FuncMap {
 			}
 			return value
 		},
+		"date":          formatDate,
+		"now":           time.Now,
+		"truncatechars": truncateChars,
+		"pluralize":     pluralize,
+		"json": func(value interface{}) (template.JS, error) {
+			data, err := json.Marshal(value)
+			return template.JS(data), err
+		},
+		"urlencode": url.QueryEscape,
+		"safehtml": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+		// Replaced with the request's messages when rendering through
+		// gojango.Context; outside a request there are none
+		"flashes": func() []interface{} {
+			return nil
+		},
+	}
+}
+
+// formatDate formats a time.Time (or *time.Time) with a Go layout; zero and
+// nil times render as "". Usage: {{.CreatedAt | date "Jan 2, 2006"}}
+func formatDate(layout string, value interface{}) string {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		t = *v
+	default:
+		return ""
+	}
+
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// truncateChars shortens s to at most n characters, ending with "…" when
+// cut, like Django's truncatechars. Usage: {{.Body | truncatechars 100}}
+func truncateChars(n int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// pluralize returns a plural suffix for a count, like Django's pluralize:
+// {{.Count | pluralize}} gives "s", {{.Count | pluralize "es"}} gives "es",
+// and {{.Count | pluralize "y,ies"}} gives "y" or "ies"
+func pluralize(args ...interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	singular, plural := "", "s"
+	if len(args) > 1 {
+		suffix := fmt.Sprint(args[0])
+		if parts := strings.SplitN(suffix, ",", 2); len(parts) == 2 {
+			singular, plural = parts[0], parts[1]
+		} else {
+			plural = suffix
+		}
+	}
+
+	if fmt.Sprint(args[len(args)-1]) == "1" {
+		return singular
 	}
+	return plural
 }
@@ -0,0 +1,402 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455): the opening handshake and a message framer.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types, matching the frame opcodes
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close status codes
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseMessageTooBig    = 1009
+	CloseNoStatusReceived = 1005
+)
+
+// DefaultReadLimit is the largest message a Conn accepts unless changed with SetReadLimit
+const DefaultReadLimit = 32 << 20
+
+// acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrReadLimit is returned when a message exceeds the connection's read limit
+var ErrReadLimit = errors.New("websocket: message exceeds read limit")
+
+// CloseError is returned by ReadMessage when the peer closes the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeMu   sync.Mutex
+	readLimit int64
+	closed    bool
+}
+
+// Option configures Upgrade
+type Option func(*options)
+
+// options holds the settings applied by Option values
+type options struct {
+	checkOrigin func(r *http.Request) bool
+}
+
+// AllowOrigins accepts handshakes from the given origins (e.g.
+// "https://app.example.com") besides the request's own host; "*" accepts
+// any origin
+func AllowOrigins(origins ...string) Option {
+	return func(o *options) {
+		o.checkOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range origins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			return sameOrigin(r)
+		}
+	}
+}
+
+// CheckOrigin replaces the origin check with fn, which reports whether the
+// handshake may proceed
+func CheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(o *options) {
+		o.checkOrigin = fn
+	}
+}
+
+// Upgrade performs the opening handshake and takes over the connection.
+// On failure it answers the request with an error status and returns the error.
+//
+// Browsers send cookies with cross-site WebSocket handshakes, so by default
+// a handshake whose Origin header names another host is refused with 403.
+// Clients that send no Origin, which browsers always do, are accepted. Use
+// AllowOrigins or CheckOrigin to accept other sites.
+func Upgrade(w http.ResponseWriter, r *http.Request, opts ...Option) (*Conn, error) {
+	o := options{checkOrigin: sameOrigin}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if r.Method != http.MethodGet {
+		return nil, handshakeError(w, http.StatusMethodNotAllowed, "websocket: upgrade requires GET")
+	}
+
+	if !headerContains(r.Header, "Connection", "upgrade") {
+		return nil, handshakeError(w, http.StatusBadRequest, "websocket: missing 'Connection: upgrade' header")
+	}
+
+	if !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, handshakeError(w, http.StatusBadRequest, "websocket: missing 'Upgrade: websocket' header")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, handshakeError(w, http.StatusUpgradeRequired, "websocket: unsupported version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, handshakeError(w, http.StatusBadRequest, "websocket: invalid Sec-WebSocket-Key")
+	}
+
+	if !o.checkOrigin(r) {
+		return nil, handshakeError(w, http.StatusForbidden, "websocket: origin "+r.Header.Get("Origin")+" not allowed")
+	}
+
+	// The controller follows Unwrap through middleware writers
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil, handshakeError(w, http.StatusInternalServerError, "websocket: response does not support hijacking")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack failed: %v", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:      netConn,
+		reader:    rw.Reader,
+		readLimit: DefaultReadLimit,
+	}, nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// sameOrigin reports whether the request has no Origin header or one naming
+// the request's own host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// handshakeError answers a failed handshake and returns it as an error
+func handshakeError(w http.ResponseWriter, status int, message string) error {
+	http.Error(w, http.StatusText(status), status)
+	return errors.New(message)
+}
+
+// headerContains reports whether a comma-separated header holds token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetReadDeadline sets the deadline for reading the next message
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing messages
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the peer's network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage reads the next text or binary message, reassembling fragments.
+// Pings are answered automatically. When the peer closes the connection the
+// close is acknowledged and a *CloseError is returned.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatusReceived}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			c.writeFrame(CloseMessage, payload)
+			c.conn.Close()
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			messageType, data = opcode, payload
+		default:
+			c.closeWithCode(CloseProtocolError, "unexpected opcode")
+			return 0, nil, fmt.Errorf("websocket: unexpected opcode %d", opcode)
+		}
+
+		// Collect continuation frames until FIN, answering interleaved control frames
+		for !fin {
+			var frameOpcode int
+			var framePayload []byte
+			fin, frameOpcode, framePayload, err = c.readFrame()
+			if err != nil {
+				return 0, nil, err
+			}
+
+			switch frameOpcode {
+			case 0:
+				if int64(len(data)+len(framePayload)) > c.readLimit {
+					c.closeWithCode(CloseMessageTooBig, "")
+					return 0, nil, ErrReadLimit
+				}
+				data = append(data, framePayload...)
+			case PingMessage:
+				if err := c.writeFrame(PongMessage, framePayload); err != nil {
+					return 0, nil, err
+				}
+				fin = false
+			case PongMessage:
+				fin = false
+			default:
+				c.closeWithCode(CloseProtocolError, "expected continuation frame")
+				return 0, nil, fmt.Errorf("websocket: expected continuation frame, got opcode %d", frameOpcode)
+			}
+		}
+
+		return messageType, data, nil
+	}
+}
+
+// readFrame reads and unmasks a single frame
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	// Clients must mask every frame they send
+	if !masked {
+		c.closeWithCode(CloseProtocolError, "frames must be masked")
+		return false, 0, nil, errors.New("websocket: received unmasked frame")
+	}
+
+	if length < 0 || length > c.readLimit {
+		c.closeWithCode(CloseMessageTooBig, "")
+		return false, 0, nil, ErrReadLimit
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a single-frame message. It is safe to call concurrently.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage, PingMessage, PongMessage, CloseMessage:
+	default:
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(text string) error {
+	return c.writeFrame(TextMessage, []byte(text))
+}
+
+// writeFrame writes an unmasked, final frame
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := []byte{0x80 | byte(opcode)}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	if opcode == CloseMessage {
+		c.closed = true
+	}
+	return nil
+}
+
+// closeWithCode sends a close frame with a status code and reason
+func (c *Conn) closeWithCode(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(CloseMessage, append(payload, reason...))
+}
+
+// Close sends a normal close frame and closes the underlying connection
+func (c *Conn) Close() error {
+	c.closeWithCode(CloseNormalClosure, "")
+	return c.conn.Close()
+}
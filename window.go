@@ -0,0 +1,165 @@
+package gojango
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// windowFunctions are the ranking functions AnnotateWindow accepts
+var windowFunctions = map[string]bool{
+	"ROW_NUMBER":   true,
+	"RANK":         true,
+	"DENSE_RANK":   true,
+	"PERCENT_RANK": true,
+	"CUME_DIST":    true,
+}
+
+// windowDrivers are the drivers whose databases support window functions
+var windowDrivers = map[string]bool{
+	"sqlite3":  true,
+	"postgres": true,
+	"pgx":      true,
+}
+
+// identifierPattern matches names usable as a column alias
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// window is the OVER clause built by WindowOption values
+type window struct {
+	partitionBy []string
+	orderBy     []string
+}
+
+// WindowOption configures the OVER clause of AnnotateWindow
+type WindowOption func(*window)
+
+// PartitionBy restarts the window function for each distinct value of the
+// given columns, e.g. to rank players per team
+func PartitionBy(columns ...string) WindowOption {
+	return func(w *window) {
+		w.partitionBy = append(w.partitionBy, columns...)
+	}
+}
+
+// OrderBy orders the rows of the window; prefix a column with "-" for
+// descending order, as with QuerySet.OrderBy
+func OrderBy(columns ...string) WindowOption {
+	return func(w *window) {
+		w.orderBy = append(w.orderBy, columns...)
+	}
+}
+
+// AnnotateWindow adds a window function column named alias to the query:
+//
+//	rows, err := qs.AnnotateWindow("rank", "ROW_NUMBER", gojango.OrderBy("-score")).
+//		OrderBy("rank").Values()
+//	// SELECT *, ROW_NUMBER() OVER (ORDER BY score DESC) AS rank FROM ... ORDER BY rank ASC
+//
+// The ranking functions ROW_NUMBER, RANK, DENSE_RANK, PERCENT_RANK and
+// CUME_DIST are supported, on SQLite and PostgreSQL; other drivers get an
+// error when the query runs. Annotations aren't model fields, so read them
+// with Values.
+func (qs *QuerySet) AnnotateWindow(alias, function string, opts ...WindowOption) *QuerySet {
+	newQS := qs.Clone()
+
+	function = strings.ToUpper(function)
+	if !windowDrivers[qs.db.Driver()] {
+		newQS.setErr(fmt.Errorf("window functions are not supported by the %s driver", qs.db.Driver()))
+		return newQS
+	}
+	if !windowFunctions[function] {
+		newQS.setErr(fmt.Errorf("unsupported window function %q", function))
+		return newQS
+	}
+	if !identifierPattern.MatchString(alias) {
+		newQS.setErr(fmt.Errorf("invalid annotation name %q", alias))
+		return newQS
+	}
+
+	w := &window{}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	var over []string
+	if len(w.partitionBy) > 0 {
+		if err := qs.checkColumns(w.partitionBy); err != nil {
+			newQS.setErr(err)
+			return newQS
+		}
+		over = append(over, "PARTITION BY "+strings.Join(w.partitionBy, ", "))
+	}
+	if len(w.orderBy) > 0 {
+		terms := make([]string, len(w.orderBy))
+		names := make([]string, len(w.orderBy))
+		for i, field := range w.orderBy {
+			names[i] = strings.TrimPrefix(field, "-")
+			terms[i] = names[i] + " ASC"
+			if strings.HasPrefix(field, "-") {
+				terms[i] = names[i] + " DESC"
+			}
+		}
+		if err := qs.checkColumns(names); err != nil {
+			newQS.setErr(err)
+			return newQS
+		}
+		over = append(over, "ORDER BY "+strings.Join(terms, ", "))
+	}
+
+	newQS.annotations = append(newQS.annotations,
+		fmt.Sprintf("%s() OVER (%s) AS %s", function, strings.Join(over, " "), alias))
+	return newQS
+}
+
+// Values runs the query and returns each row as a map from column name (or
+// annotation alias) to value, like Django's values(). Text comes back as
+// string; other values are as the driver returns them.
+func (qs *QuerySet) Values() ([]map[string]interface{}, error) {
+	if qs.err != nil {
+		return nil, qs.err
+	}
+
+	if mock := qs.db.Mock(); mock != nil {
+		records := qs.mockRecords(mock)
+		for _, record := range records {
+			for column, value := range record {
+				record[column] = indirect(value)
+			}
+		}
+		return records, nil
+	}
+
+	rows, err := qs.db.QueryContext(qs.context(), qs.buildSQL(), qs.args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dests := make([]interface{}, len(columns))
+		for i := range values {
+			dests[i] = &values[i]
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}